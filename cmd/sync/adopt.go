@@ -0,0 +1,91 @@
+package main
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/postgres"
+    "github.com/Dataloh/pg-ldap-sync/internal/scope"
+)
+
+// adopt marks the roles that earlier versions managed by name alone, i.e. members of the
+// default group within the user scope, with the configured ownership marker. It is meant
+// to be run once when enabling sync_policy.ownership, and returns the process exit code.
+func adopt(dryRun bool) int {
+    log.Println("Adopting existing roles...")
+    if dryRun {
+        log.Println("DRY-RUN: no roles will be marked.")
+    }
+
+    configPath := getConfigPath()
+    log.Printf("Loading configuration from: %s", configPath)
+    cfg, err := config.Load(configPath)
+    if err != nil {
+        log.Printf("Failed to load configuration: %v", err)
+        return exitFailure
+    }
+    if cfg.SyncPolicy.Ownership.Marker == "" {
+        log.Println("No sync_policy.ownership.marker is configured; nothing to adopt.")
+        return exitFailure
+    }
+    userScope, err := scope.New(cfg.SyncPolicy)
+    if err != nil {
+        log.Printf("Invalid user scope: %v", err)
+        return exitFailure
+    }
+    if userScope.Empty() {
+        log.Println("No managed user scope is configured; nothing to adopt.")
+        return exitFailure
+    }
+
+    failed := 0
+    pending := 0
+    for _, dbCfg := range cfg.Databases {
+        log.Printf("--- Processing database: %s ---", dbCfg.Alias)
+        adopted, err := adoptDatabase(cfg, dbCfg, userScope, dryRun)
+        if err != nil {
+            log.Printf("ERROR: Adoption failed for database '%s': %v", dbCfg.Alias, err)
+            failed++
+            continue
+        }
+        pending += len(adopted)
+        if dryRun {
+            roles := make([]string, len(adopted))
+            for i, adoption := range adopted {
+                roles[i] = adoption.Role
+            }
+            log.Printf("DRY-RUN [%s]: %d role(s) would be adopted: %v", dbCfg.Alias, len(adopted), roles)
+            for _, adoption := range adopted {
+                if adoption.Existing != "" {
+                    log.Printf("DRY-RUN [%s]: Role '%s' already has the comment %q; the marker would be put in front of it.", dbCfg.Alias, adoption.Role, adoption.Existing)
+                }
+            }
+        } else {
+            log.Printf("[%s]: %d role(s) adopted.", dbCfg.Alias, len(adopted))
+        }
+    }
+
+    if failed > 0 {
+        log.Printf("Adoption finished with errors: %d of %d database(s) failed.", failed, len(cfg.Databases))
+        return exitFailure
+    }
+    if dryRun && pending > 0 {
+        return exitChangesPending
+    }
+    return 0
+}
+
+func adoptDatabase(cfg *config.Config, dbCfg config.DatabaseConfig, userScope *scope.Matcher, dryRun bool) ([]postgres.Adoption, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+    defer cancel()
+
+    pgClient := postgres.NewClient(dbCfg.Postgres)
+    if err := pgClient.Connect(ctx); err != nil {
+        return nil, err
+    }
+    defer pgClient.Close()
+
+    return pgClient.Adopt(ctx, cfg.SyncPolicy.DefaultPostgresGroup, userScope, cfg.SyncPolicy.Ownership, dryRun)
+}
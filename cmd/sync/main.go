@@ -2,19 +2,62 @@ package main
 
 import (
     "context"
+    "flag"
+    "fmt"
     "log"
     "path/filepath"
-    "strings"
-    "time"
     "os"
 
     "github.com/Dataloh/pg-ldap-sync/internal/config"
     "github.com/Dataloh/pg-ldap-sync/internal/ldap"
-    "github.com/Dataloh/pg-ldap-sync/internal/postgres"
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
 )
 
+const (
+    // exitFailure is returned when the run could not start or any database failed to sync.
+    exitFailure = 1
+    // exitChangesPending is returned by --dry-run when the computed plan is not empty.
+    exitChangesPending = 2
+    // exitLimitExceeded is returned when a database plan exceeded the sync_policy.safety
+    // limits and was not applied.
+    exitLimitExceeded = 3
+)
+
+// Usage:
+//
+//	pg-ldap-sync [flags]         run a single sync pass and exit
+//	pg-ldap-sync serve [flags]   run sync passes on the configured interval until SIGTERM
+//	pg-ldap-sync adopt [flags]   mark existing in-scope roles as managed, see sync_policy.ownership
 func main() {
+    args := os.Args[1:]
+    command := ""
+    if len(args) > 0 && (args[0] == "serve" || args[0] == "adopt") {
+        command, args = args[0], args[1:]
+    }
+
+    dryRun := flag.Bool("dry-run", false, "compute and print all changes without executing them")
+    planJSON := flag.String("plan-json", "", "write the sync plan as JSON to this file ('-' for stdout)")
+    force := flag.Bool("force", false, "apply changes even if they exceed the sync_policy.safety limits")
+    flag.CommandLine.Parse(args)
+
+    switch command {
+    case "serve":
+        if *force {
+            log.Println("WARNING: --force is ignored in daemon mode.")
+        }
+        os.Exit(serve(*dryRun, *planJSON))
+    case "adopt":
+        os.Exit(adopt(*dryRun))
+    }
+    os.Exit(run(*dryRun, *force, *planJSON))
+}
+
+// run performs a single sync pass over all configured databases and returns the process exit code.
+func run(dryRun, force bool, planJSON string) int {
     log.Println("Starting LDAP to Postgres sync process...")
+    if dryRun {
+        log.Println("DRY-RUN: no changes will be made to any database.")
+    }
     ctx := context.Background()
 
     // --- Configuration Loading ---
@@ -22,7 +65,8 @@ func main() {
     log.Printf("Loading configuration from: %s", configPath)
     cfg, err := config.Load(configPath)
     if err != nil {
-        log.Fatalf("Failed to load configuration: %v", err)
+        log.Printf("Failed to load configuration: %v", err)
+        return exitFailure
     }
     log.Println("Configuration loaded successfully.")
 
@@ -30,96 +74,47 @@ func main() {
     log.Println("Initializing LDAP client...")
     ldapClient := ldap.NewClient(cfg.LDAP)
     if err := ldapClient.Connect(); err != nil {
-        log.Fatalf("Failed to connect to LDAP server: %v", err)
+        log.Printf("Failed to connect to LDAP server: %v", err)
+        failPass(cfg, passOptions{dryRun: dryRun, force: force}, fmt.Errorf("could not connect to LDAP server: %w", err)).report(cfg, "")
+        return exitFailure
     }
     defer ldapClient.Close()
 
-    // --- Main Sync Loop ---
-    for _, dbCfg := range cfg.Databases {
-        log.Printf("--- Processing database: %s ---", dbCfg.Alias)
+    pgClients := newPgClientCache(false)
+    defer pgClients.closeAll()
 
-        pgClient := postgres.NewClient(dbCfg.Postgres)
-        if err := pgClient.Connect(ctx); err != nil {
-            log.Fatalf("ERROR: Could not connect to PostgreSQL database '%s': %v. Skipping.", dbCfg.Alias, err)
-            continue
-        }
-
-        // == Phase 1: User Provisioning ==
-        // First, gather all valid users from all configured LDAP groups for this DB.
-        allValidLdapUsers := make(map[string]bool)
-        allRoleMappings := make(map[string][]string) // Store members for Phase 2
-
-        log.Println("Phase 1: Fetching and filtering all LDAP users...")
-        for _, roleMap := range dbCfg.Roles {
-            ldapMembers, err := ldapClient.FetchGroupMembers(roleMap.LDAPGroupCN)
-            if err != nil {
-                log.Printf("    ERROR fetching LDAP members for '%s': %v", roleMap.LDAPGroupCN, err)
-                continue
-            }
-
-            var filteredMembers []string
-            for _, member := range ldapMembers {
-                isValid := false
-                for _, prefix := range cfg.SyncPolicy.AllowedUserPrefixes {
-                    if strings.HasPrefix(member, prefix) {
-                        isValid = true
-                        break
-                    }
-                }
-                if isValid {
-                    filteredMembers = append(filteredMembers, member)
-                    allValidLdapUsers[member] = true
-                }
-            }
-            allRoleMappings[roleMap.PostgresRole] = filteredMembers
-        }
+    // --- LDAP Resolution ---
+    // Every group is resolved once and shared by all databases that map it.
+    groups := resolveGroups(ldapClient, cfg)
 
-        // Convert map keys to a slice for the creation function.
-        var usersToCreate []string
-        for user := range allValidLdapUsers {
-            usersToCreate = append(usersToCreate, user)
+    result := syncPass(ctx, cfg, groups, pgClients, passOptions{dryRun: dryRun, force: force})
+    if !result.report(cfg, planJSON) {
+        if result.limitExceeded() {
+            return exitLimitExceeded
         }
-
-        // Now, run a single transaction to create all missing users.
-        provCtx, cancelProv := context.WithTimeout(ctx, 60*time.Second)
-        log.Println("Phase 1: Ensuring all valid users exist in PostgreSQL...")
-        if err := pgClient.EnsureUsersExist(provCtx, usersToCreate, cfg.SyncPolicy.DefaultPostgresGroup); err != nil {
-            log.Fatalf("ERROR during user provisioning phase: %v. Skipping membership sync for this DB.", err)
-            cancelProv()
-            pgClient.Close()
-            continue
-        }
-        cancelProv()
-        log.Println("Phase 1: User provisioning complete.")
-
-        // == Phase 2: Membership Sync ==
-        log.Println("Phase 2: Synchronizing group memberships...")
-        for pgRole, members := range allRoleMappings {
-            log.Printf("--> Syncing membership for: [%s]", pgRole)
-            syncCtx, cancelSync := context.WithTimeout(ctx, 30*time.Second)
-            err = pgClient.SyncRoleMembership(syncCtx, pgRole, members, cfg.SyncPolicy.AllowedUserPrefixes)
-            if err != nil {
-                log.Fatalf("    ERROR: Failed to sync role membership: %v", err)
-            } else {
-                log.Printf("    SUCCESS: PostgreSQL role '%s' is synchronized.", pgRole)
-            }
-            cancelSync()
-        }
-        log.Println("Phase 2: Membership sync complete.")
-
-        // == Phase 3: Deprovisioning ==
-        deprovisionCtx, cancelDeprov := context.WithTimeout(ctx, 30*time.Second)
-        if err := pgClient.DeprovisionUsers(deprovisionCtx, allValidLdapUsers, cfg.SyncPolicy.DefaultPostgresGroup, cfg.SyncPolicy.AllowedUserPrefixes); err != nil {
-            log.Fatalf("ERROR: Failed to deprovision users: %v", err)
-        } else {
-            log.Println("Phase 3: Deprovisioning complete.")
-        }
-        cancelDeprov()
-
-        pgClient.Close()
+        return exitFailure
+    }
+    if dryRun && result.plan.Len() > 0 {
+        log.Printf("DRY-RUN: %d change(s) pending.", result.plan.Len())
+        return exitChangesPending
     }
+    return 0
+}
 
-    log.Println("Sync process finished.")
+// writePlanJSON writes the run plan as JSON to path, or to stdout if path is "-".
+func writePlanJSON(path string, p *plan.Plan) error {
+    if path == "-" {
+        return plan.RenderJSON(os.Stdout, p)
+    }
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    if err := plan.RenderJSON(f, p); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
 }
 
 // getConfigPath determines the path to the config.yml file.
@@ -139,4 +134,3 @@ func getConfigPath() string {
 
     return configPath
 }
-
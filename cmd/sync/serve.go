@@ -0,0 +1,324 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "math/rand/v2"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/ldap"
+    "github.com/Dataloh/pg-ldap-sync/internal/metrics"
+)
+
+// watchRetryDelay is how long a failed syncrepl watch waits before reconnecting.
+const watchRetryDelay = 30 * time.Second
+
+// daemon holds the state that is kept warm between sync passes in serve mode.
+type daemon struct {
+    configPath string
+    cfg        *config.Config
+    ldapClient *ldap.Client
+    pgClients  *pgClientCache
+    // groups is the LDAP view from the last full pass, updated by targeted passes.
+    groups       map[string]groupResult
+    stopWatchers context.CancelFunc
+}
+
+// serve runs sync passes on the configured interval until SIGTERM or SIGINT.
+// Passes never overlap: a pass that comes due while another is running starts once it
+// has finished. SIGHUP reloads config.yml between passes. On shutdown the current pass
+// is allowed to finish its in-flight transactions, but no new database or phase is started.
+//
+// With daemon.watch enabled, LDAP changes reported by syncrepl trigger a targeted pass
+// for the affected roles only, after a short debounce that batches bursts of changes.
+func serve(dryRun bool, planJSON string) int {
+    log.Println("Starting LDAP to Postgres sync daemon...")
+
+    configPath := getConfigPath()
+    log.Printf("Loading configuration from: %s", configPath)
+    cfg, err := config.Load(configPath)
+    if err != nil {
+        log.Printf("Failed to load configuration: %v", err)
+        return exitFailure
+    }
+    log.Println("Configuration loaded successfully.")
+
+    d := &daemon{
+        configPath: configPath,
+        cfg:        cfg,
+        ldapClient: ldap.NewClient(cfg.LDAP),
+        pgClients:  newPgClientCache(true),
+    }
+    defer d.close()
+
+    // The metrics endpoint is bound once at startup; changing its address requires a restart.
+    if addr := cfg.Metrics.ListenAddress; addr != "" {
+        mux := http.NewServeMux()
+        mux.Handle("/metrics", metrics.Handler())
+        srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+        go func() {
+            log.Printf("Serving metrics on %s/metrics", addr)
+            if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                log.Printf("ERROR: Metrics endpoint stopped: %v", err)
+            }
+        }()
+        defer srv.Close()
+    }
+
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+    defer signal.Stop(sigs)
+
+    events := make(chan ldap.ChangeEvent, 256)
+    d.startWatchers(events)
+
+    stop := make(chan struct{})
+    passDone := make(chan bool) // true when the finished pass was a full pass
+    timer := time.NewTimer(0)   // The first pass starts immediately.
+    debounce := time.NewTimer(0)
+    if !debounce.Stop() {
+        <-debounce.C
+    }
+    debounceArmed := false
+    pendingDNs := make(map[string]bool)
+    running := false
+    stopping := false
+    reloadPending := false
+    fullDue := false
+    targetedDue := false
+
+    startFull := func() {
+        running, fullDue = true, false
+        go func() {
+            d.fullPass(passOptions{dryRun: dryRun, stop: stop}, planJSON)
+            passDone <- true
+        }()
+    }
+    startTargeted := func() {
+        dns := make([]string, 0, len(pendingDNs))
+        for dn := range pendingDNs {
+            dns = append(dns, dn)
+        }
+        pendingDNs = make(map[string]bool)
+        running, targetedDue = true, false
+        go func() {
+            d.targetedPass(passOptions{dryRun: dryRun, stop: stop}, dns, planJSON)
+            passDone <- false
+        }()
+    }
+
+    for {
+        select {
+        case <-timer.C:
+            if running {
+                fullDue = true
+            } else {
+                startFull()
+            }
+
+        case event := <-events:
+            if event.Resync {
+                log.Println("LDAP reported changes that cannot be attributed to entries; scheduling a full pass.")
+                if running {
+                    fullDue = true
+                } else {
+                    startFull()
+                }
+                continue
+            }
+            pendingDNs[event.DN] = true
+            if !debounceArmed {
+                debounce.Reset(d.cfg.Daemon.Watch.Debounce)
+                debounceArmed = true
+            }
+
+        case <-debounce.C:
+            debounceArmed = false
+            if len(pendingDNs) == 0 {
+                continue
+            }
+            if running {
+                targetedDue = true
+            } else {
+                startTargeted()
+            }
+
+        case wasFull := <-passDone:
+            running = false
+            if stopping {
+                log.Println("Current pass finished. Shutting down.")
+                return 0
+            }
+            if reloadPending {
+                d.reload(events)
+                reloadPending = false
+            }
+            if wasFull {
+                delay := d.nextDelay()
+                log.Printf("Next sync pass in %s.", delay.Round(time.Second))
+                timer.Reset(delay)
+            }
+            if fullDue {
+                startFull()
+            } else if targetedDue && len(pendingDNs) > 0 {
+                startTargeted()
+            }
+
+        case sig := <-sigs:
+            switch sig {
+            case syscall.SIGHUP:
+                if running {
+                    log.Println("Received SIGHUP; configuration will be reloaded after the current pass.")
+                    reloadPending = true
+                } else {
+                    d.reload(events)
+                }
+            default:
+                if stopping {
+                    continue
+                }
+                stopping = true
+                close(stop)
+                if !running {
+                    log.Printf("Received %s. Shutting down.", sig)
+                    return 0
+                }
+                log.Printf("Received %s. Finishing the current pass before shutting down...", sig)
+            }
+        }
+    }
+}
+
+// connectLDAP (re)connects the one-shot LDAP connection if it is not usable.
+func (d *daemon) connectLDAP() error {
+    if d.ldapClient.IsConnected() {
+        return nil
+    }
+    d.ldapClient.Close()
+    return d.ldapClient.Connect()
+}
+
+// fullPass resolves every LDAP group from scratch and synchronizes every database.
+func (d *daemon) fullPass(opts passOptions, planJSON string) {
+    log.Println("Starting sync pass...")
+    if err := d.connectLDAP(); err != nil {
+        log.Printf("ERROR: Failed to connect to LDAP server, skipping this pass: %v", err)
+        failPass(d.cfg, opts, fmt.Errorf("could not connect to LDAP server: %w", err)).report(d.cfg, "")
+        return
+    }
+
+    // The pass context is deliberately not tied to the shutdown signal, so in-flight
+    // transactions are allowed to complete.
+    d.groups = resolveGroups(d.ldapClient, d.cfg)
+    result := syncPass(context.Background(), d.cfg, d.groups, d.pgClients, opts)
+    result.report(d.cfg, planJSON)
+}
+
+// targetedPass re-resolves only the LDAP groups affected by the changed entries and
+// reconciles the memberships of the roles mapped to them.
+func (d *daemon) targetedPass(opts passOptions, changedDNs []string, planJSON string) {
+    if d.groups == nil {
+        // No full pass has completed yet; it will pick up these changes.
+        return
+    }
+
+    groupCNs := d.ldapClient.Invalidate(changedDNs)
+    if len(groupCNs) == 0 {
+        log.Printf("%d LDAP change(s) do not affect any mapped group.", len(changedDNs))
+        return
+    }
+    opts.onlyGroups = make(map[string]bool, len(groupCNs))
+    for _, cn := range groupCNs {
+        opts.onlyGroups[cn] = true
+    }
+
+    if err := d.connectLDAP(); err != nil {
+        log.Printf("ERROR: Failed to connect to LDAP server, skipping targeted sync: %v", err)
+        failPass(d.cfg, opts, fmt.Errorf("could not connect to LDAP server: %w", err)).report(d.cfg, "")
+        return
+    }
+    log.Printf("Starting targeted sync of group(s) %v after %d LDAP change(s)...", groupCNs, len(changedDNs))
+    d.groups = refreshGroups(d.ldapClient, d.cfg, d.groups, groupCNs)
+    result := syncPass(context.Background(), d.cfg, d.groups, d.pgClients, opts)
+    result.report(d.cfg, planJSON)
+}
+
+// startWatchers opens a syncrepl watch on the group and user search bases, if enabled.
+func (d *daemon) startWatchers(events chan<- ldap.ChangeEvent) {
+    if !d.cfg.Daemon.Watch.Enabled {
+        return
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    d.stopWatchers = cancel
+
+    bases := []string{d.cfg.LDAP.GroupSearchBase}
+    if d.cfg.LDAP.UserSearchBase != "" && d.cfg.LDAP.UserSearchBase != d.cfg.LDAP.GroupSearchBase {
+        bases = append(bases, d.cfg.LDAP.UserSearchBase)
+    }
+    for _, base := range bases {
+        go watch(ctx, d.ldapClient, base, events)
+    }
+}
+
+// watch keeps a syncrepl watch on baseDN running until ctx is cancelled, reconnecting
+// after failures and resuming from the last cookie.
+func watch(ctx context.Context, client *ldap.Client, baseDN string, events chan<- ldap.ChangeEvent) {
+    var cookie []byte
+    for {
+        var err error
+        cookie, err = client.Watch(ctx, baseDN, cookie, events)
+        if ctx.Err() != nil {
+            return
+        }
+        log.Printf("ERROR: LDAP watch on '%s' stopped, retrying in %s: %v", baseDN, watchRetryDelay, err)
+        select {
+        case <-time.After(watchRetryDelay):
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// reload re-reads config.yml. On failure the previous configuration stays in effect.
+func (d *daemon) reload(events chan<- ldap.ChangeEvent) {
+    log.Printf("Reloading configuration from: %s", d.configPath)
+    cfg, err := config.Load(d.configPath)
+    if err != nil {
+        log.Printf("ERROR: Failed to reload configuration, keeping the previous one: %v", err)
+        return
+    }
+
+    // Connection settings may have changed, so start from fresh connections.
+    d.close()
+    d.cfg = cfg
+    d.ldapClient = ldap.NewClient(cfg.LDAP)
+    d.groups = nil
+    d.startWatchers(events)
+    log.Println("Configuration reloaded successfully.")
+}
+
+// nextDelay returns the configured interval plus a random jitter.
+func (d *daemon) nextDelay() time.Duration {
+    delay := d.cfg.Daemon.Interval
+    if d.cfg.Daemon.Jitter > 0 {
+        delay += rand.N(d.cfg.Daemon.Jitter)
+    }
+    return delay
+}
+
+// close releases all warm connections and stops the watchers.
+func (d *daemon) close() {
+    if d.stopWatchers != nil {
+        d.stopWatchers()
+        d.stopWatchers = nil
+    }
+    d.pgClients.closeAll()
+    d.ldapClient.Close()
+}
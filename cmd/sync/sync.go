@@ -0,0 +1,774 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "maps"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/ldap"
+    "github.com/Dataloh/pg-ldap-sync/internal/metrics"
+    "github.com/Dataloh/pg-ldap-sync/internal/naming"
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
+    "github.com/Dataloh/pg-ldap-sync/internal/postgres"
+    "github.com/Dataloh/pg-ldap-sync/internal/scope"
+)
+
+// errInterrupted is returned for databases that were skipped or cut short by a shutdown.
+var errInterrupted = errors.New("interrupted by shutdown")
+
+// passOptions controls a single sync pass.
+type passOptions struct {
+    dryRun bool
+    // force applies plans that exceed the sync_policy.safety limits.
+    force bool
+    // onlyGroups restricts a targeted pass to the roles mapped to these LDAP group CNs.
+    // Deprovisioning needs the complete LDAP view and is skipped in a targeted pass.
+    // Nil for a full pass.
+    onlyGroups map[string]bool
+    // stop is closed when the process is shutting down. Databases that have not started
+    // yet are skipped, and running ones stop between transactions. Nil in one-shot mode.
+    stop <-chan struct{}
+}
+
+// stopping reports whether a shutdown has been requested.
+func (o passOptions) stopping() bool {
+    select {
+    case <-o.stop:
+        return true
+    default:
+        return false
+    }
+}
+
+// targets reports whether a pass with these options reconciles roles mapped to groupCN.
+func (o passOptions) targets(groupCN string) bool {
+    return o.onlyGroups == nil || o.onlyGroups[groupCN]
+}
+
+// pass returns the metrics label of a pass with these options.
+func (o passOptions) pass() string {
+    if o.onlyGroups != nil {
+        return metrics.PassTargeted
+    }
+    return metrics.PassFull
+}
+
+// targetsAny reports whether a pass with these options reconciles any of the roles.
+func (o passOptions) targetsAny(roles []config.RoleMap) bool {
+    for _, roleMap := range roles {
+        if o.targets(roleMap.LDAPGroupCN) {
+            return true
+        }
+    }
+    return false
+}
+
+// passResult is the outcome of a sync pass over all configured databases.
+type passResult struct {
+    plan     *plan.Plan
+    failures map[string]error // keyed by database alias
+}
+
+// syncPass synchronizes every configured database with a bounded pool of workers, using
+// LDAP groups resolved beforehand by resolveGroups. A failing database is recorded and
+// skipped so it cannot block access changes elsewhere.
+func syncPass(ctx context.Context, cfg *config.Config, groups map[string]groupResult, pgClients *pgClientCache, opts passOptions) passResult {
+    start := time.Now()
+    runPlan := plan.New(opts.dryRun)
+    pass := opts.pass()
+
+    // --- Main Sync Loop ---
+    log.Printf("Synchronizing %d database(s) with concurrency %d...", len(cfg.Databases), cfg.SyncPolicy.Concurrency)
+    results := make([]dbResult, len(cfg.Databases))
+
+    // Pre-flight: every group role missing in any database is reported at once, before
+    // any database is changed. Only the databases missing a role are left out; the
+    // others are synchronized as usual.
+    var missing map[string][]string
+    if !cfg.SyncPolicy.CreateMissingRoles {
+        missing = checkGroupRoles(ctx, cfg, pgClients, opts)
+        if len(missing) > 0 {
+            for _, alias := range slices.Sorted(maps.Keys(missing)) {
+                log.Printf("ERROR [%s]: Group roles %v do not exist.", alias, missing[alias])
+            }
+            log.Printf("ERROR: Group roles are missing in %d database(s); they are not synchronized. Create the roles or enable sync_policy.create_missing_roles.", len(missing))
+        }
+    }
+
+    sem := make(chan struct{}, cfg.SyncPolicy.Concurrency)
+    var wg sync.WaitGroup
+    for i, dbCfg := range cfg.Databases {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(i int, dbCfg config.DatabaseConfig) {
+            defer wg.Done()
+            defer func() { <-sem }()
+
+            if opts.stopping() {
+                pgClients.release(dbCfg.Alias)
+                results[i] = dbResult{err: errInterrupted}
+                return
+            }
+            if !opts.targetsAny(dbCfg.Roles) {
+                return
+            }
+            if roles, ok := missing[dbCfg.Alias]; ok {
+                pgClients.release(dbCfg.Alias)
+                results[i] = dbResult{err: fmt.Errorf("group roles %v do not exist; create them or enable sync_policy.create_missing_roles", roles)}
+                return
+            }
+            log.Printf("--- Processing database: %s ---", dbCfg.Alias)
+            dbStart := time.Now()
+            dbPlan, err := syncDatabase(ctx, cfg, dbCfg, groups, pgClients, opts)
+            metrics.ObserveDatabase(dbCfg.Alias, pass, time.Since(dbStart), err == nil && !opts.dryRun)
+            if err != nil {
+                log.Printf("ERROR: Sync failed for database '%s': %v", dbCfg.Alias, err)
+            }
+            results[i] = dbResult{plan: dbPlan, err: err}
+        }(i, dbCfg)
+    }
+    wg.Wait()
+    return collectPass(runPlan, cfg, results, pass, start)
+}
+
+// failPass records a pass in which none of the databases it reconciles could be
+// synchronized because of err, e.g. when the LDAP server is unreachable.
+func failPass(cfg *config.Config, opts passOptions, err error) passResult {
+    start := time.Now()
+    results := make([]dbResult, len(cfg.Databases))
+    for i, dbCfg := range cfg.Databases {
+        if opts.targetsAny(dbCfg.Roles) {
+            results[i] = dbResult{err: err}
+        }
+    }
+    return collectPass(plan.New(opts.dryRun), cfg, results, opts.pass(), start)
+}
+
+// dbResult is the outcome of synchronizing one database in a pass.
+type dbResult struct {
+    plan *plan.DatabasePlan
+    err  error
+}
+
+// collectPass gathers the per-database results of a pass, in configuration order, and
+// records the pass metrics. Every failed database counts as an error, including those
+// that were never started.
+func collectPass(runPlan *plan.Plan, cfg *config.Config, results []dbResult, pass string, start time.Time) passResult {
+    failures := make(map[string]error)
+    for i, dbCfg := range cfg.Databases {
+        if results[i].plan != nil {
+            runPlan.Databases = append(runPlan.Databases, results[i].plan)
+        }
+        if results[i].err != nil {
+            failures[dbCfg.Alias] = results[i].err
+            metrics.AddError(dbCfg.Alias)
+        }
+    }
+    metrics.ObserveRun(pass, time.Since(start), len(failures) == 0 && !runPlan.DryRun)
+    return passResult{plan: runPlan, failures: failures}
+}
+
+// checkGroupRoles returns, by database alias, the group roles that the databases
+// reconciled by the pass need but that do not exist. Databases that cannot be checked
+// are left out; syncDatabase reports their errors. The clients stay checked out so the
+// databases are synchronized on the same connections; syncPass releases them.
+func checkGroupRoles(ctx context.Context, cfg *config.Config, pgClients *pgClientCache, opts passOptions) map[string][]string {
+    var mu sync.Mutex
+    missing := make(map[string][]string)
+    sem := make(chan struct{}, cfg.SyncPolicy.Concurrency)
+    var wg sync.WaitGroup
+    for _, dbCfg := range cfg.Databases {
+        if !opts.targetsAny(dbCfg.Roles) {
+            continue
+        }
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(dbCfg config.DatabaseConfig) {
+            defer wg.Done()
+            defer func() { <-sem }()
+
+            pgClient, err := pgClients.get(ctx, dbCfg)
+            if err != nil {
+                return
+            }
+
+            checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+            defer cancel()
+            roles, err := pgClient.MissingRoles(checkCtx, groupRoles(cfg, dbCfg))
+            if err != nil {
+                log.Printf("Warning [%s]: Pre-flight check of group roles failed: %v", dbCfg.Alias, err)
+                return
+            }
+            if len(roles) > 0 {
+                mu.Lock()
+                missing[dbCfg.Alias] = roles
+                mu.Unlock()
+            }
+        }(dbCfg)
+    }
+    wg.Wait()
+    return missing
+}
+
+// groupRoles returns the group roles a database needs: default_postgres_group and every
+// mapped role, without duplicates.
+func groupRoles(cfg *config.Config, dbCfg config.DatabaseConfig) []string {
+    var roles []string
+    if cfg.SyncPolicy.DefaultPostgresGroup != "" {
+        roles = append(roles, cfg.SyncPolicy.DefaultPostgresGroup)
+    }
+    for _, roleMap := range dbCfg.Roles {
+        if !slices.Contains(roles, roleMap.PostgresRole) {
+            roles = append(roles, roleMap.PostgresRole)
+        }
+    }
+    return roles
+}
+
+// limitExceeded reports whether any database was left untouched by the safety limits.
+func (r passResult) limitExceeded() bool {
+    for _, err := range r.failures {
+        if errors.Is(err, plan.ErrLimitExceeded) {
+            return true
+        }
+    }
+    return false
+}
+
+// report logs the outcome of a pass and writes its plan in the requested formats.
+// It returns true if every database was synchronized successfully.
+func (r passResult) report(cfg *config.Config, planJSON string) bool {
+    if r.plan.DryRun {
+        if err := plan.RenderText(log.Writer(), r.plan); err != nil {
+            log.Printf("ERROR: Failed to render plan: %v", err)
+        }
+    }
+    if planJSON != "" {
+        if err := writePlanJSON(planJSON, r.plan); err != nil {
+            log.Printf("ERROR: Failed to write JSON plan: %v", err)
+        }
+    }
+    // A dry run must not overwrite the textfile of the real runs, e.g. on a cron host.
+    if cfg.Metrics.TextfilePath != "" && !r.plan.DryRun {
+        if err := metrics.WriteTextfile(cfg.Metrics.TextfilePath); err != nil {
+            log.Printf("ERROR: Failed to write metrics textfile: %v", err)
+        }
+    }
+
+    if len(r.failures) > 0 {
+        log.Printf("Sync process finished with errors: %d of %d database(s) failed.", len(r.failures), len(cfg.Databases))
+        for _, dbCfg := range cfg.Databases {
+            if err, failed := r.failures[dbCfg.Alias]; failed {
+                log.Printf("    FAILED [%s]: %v", dbCfg.Alias, err)
+            }
+        }
+        return false
+    }
+    log.Println("Sync process finished.")
+    return true
+}
+
+// groupResult holds the resolved members of one LDAP group, or the error resolving it.
+type groupResult struct {
+    members []ldap.Member
+    // users are the members that can be synchronized, with their role names.
+    users []roleUser
+    // collisions lists role names claimed by several LDAP users; those users are left out.
+    collisions []string
+    err        error
+}
+
+// roleUser is an LDAP user together with the PostgreSQL role name derived from it.
+type roleUser struct {
+    ldap.Member
+    role string
+}
+
+// resolveGroups fetches the members of every LDAP group referenced by any database, once.
+// Nested groups shared between groups are walked only once thanks to the client's cache,
+// which is reset first so every run sees the current directory.
+func resolveGroups(ldapClient *ldap.Client, cfg *config.Config) map[string]groupResult {
+    ldapClient.ResetCache()
+    groups := make(map[string]groupResult)
+    for _, dbCfg := range cfg.Databases {
+        for _, roleMap := range dbCfg.Roles {
+            if _, done := groups[roleMap.LDAPGroupCN]; done {
+                continue
+            }
+            start := time.Now()
+            members, err := ldapClient.FetchGroupMembers(roleMap.LDAPGroupCN)
+            metrics.ObserveLDAPLookup(time.Since(start), err)
+            groups[roleMap.LDAPGroupCN] = groupResult{members: members, err: err}
+        }
+    }
+    if err := ldapClient.SaveState(); err != nil {
+        log.Printf("Warning: Failed to save incremental LDAP state: %v", err)
+    }
+    mapUsernames(cfg.SyncPolicy.UsernameRules, groups)
+    return groups
+}
+
+// refreshGroups re-resolves the given group CNs after their cached data has been
+// invalidated, and returns a copy of groups with the new results. Groups that are not
+// listed, and nested groups that were not invalidated, are served from the cache.
+func refreshGroups(ldapClient *ldap.Client, cfg *config.Config, groups map[string]groupResult, groupCNs []string) map[string]groupResult {
+    refreshed := make(map[string]groupResult, len(groups))
+    for cn, group := range groups {
+        refreshed[cn] = group
+    }
+    for _, cn := range groupCNs {
+        start := time.Now()
+        members, err := ldapClient.FetchGroupMembers(cn)
+        metrics.ObserveLDAPLookup(time.Since(start), err)
+        refreshed[cn] = groupResult{members: members, err: err}
+    }
+    if err := ldapClient.SaveState(); err != nil {
+        log.Printf("Warning: Failed to save incremental LDAP state: %v", err)
+    }
+    mapUsernames(cfg.SyncPolicy.UsernameRules, refreshed)
+    return refreshed
+}
+
+// mapUsernames derives the role name of every resolved member. Role names are checked
+// across all groups: when different LDAP users map to the same role name, none of them
+// is synchronized and the collision is recorded on every group containing them.
+func mapUsernames(rules config.UsernameRules, groups map[string]groupResult) {
+    owners := make(map[string]map[string]string) // role name -> normalized DN -> LDAP username
+    for _, group := range groups {
+        for _, member := range group.members {
+            role := naming.RoleName(rules, member.Username)
+            if role == "" {
+                continue
+            }
+            if owners[role] == nil {
+                owners[role] = make(map[string]string)
+            }
+            owners[role][strings.ToLower(member.DN)] = member.Username
+        }
+    }
+    for role, users := range owners {
+        if len(users) > 1 {
+            usernames := slices.Sorted(maps.Values(users))
+            log.Printf("ERROR: LDAP users %v all map to PostgreSQL role '%s'; none of them will be synchronized.", usernames, role)
+        }
+    }
+
+    for cn, group := range groups {
+        group.users = make([]roleUser, 0, len(group.members))
+        group.collisions = nil
+        for _, member := range group.members {
+            role := naming.RoleName(rules, member.Username)
+            if role == "" {
+                log.Printf("Warning: LDAP user '%s' maps to an empty role name. Skipping.", member.Username)
+                continue
+            }
+            if len(owners[role]) > 1 {
+                if !slices.Contains(group.collisions, role) {
+                    group.collisions = append(group.collisions, role)
+                }
+                continue
+            }
+            group.users = append(group.users, roleUser{Member: member, role: role})
+        }
+        groups[cn] = group
+    }
+}
+
+// syncDatabase plans and, unless opts.dryRun is set, applies all changes for a single database.
+// The returned plan is non-nil whenever planning succeeded, even if applying it failed.
+// It is safe to call concurrently; groups is only read.
+func syncDatabase(ctx context.Context, cfg *config.Config, dbCfg config.DatabaseConfig, groups map[string]groupResult, pgClients *pgClientCache, opts passOptions) (*plan.DatabasePlan, error) {
+    pgClient, err := pgClients.get(ctx, dbCfg)
+    if err != nil {
+        return nil, fmt.Errorf("could not connect to PostgreSQL: %w", err)
+    }
+    defer pgClients.release(dbCfg.Alias)
+
+    userScope, err := scope.New(cfg.SyncPolicy)
+    if err != nil {
+        return nil, err
+    }
+
+    // == Phase 1: User Provisioning ==
+    // First, gather all valid users from all configured LDAP groups for this DB.
+    desired := plan.Desired{
+        DefaultGroup:    cfg.SyncPolicy.DefaultPostgresGroup,
+        Scope:           userScope,
+        Ownership:       cfg.SyncPolicy.Ownership,
+        Deprovision:     dbCfg.Deprovision,
+        Users:           make(map[string]plan.Source),
+        Roles:           make(map[string]plan.RoleTarget), // Store members for Phase 2
+        SkipDeprovision: opts.onlyGroups != nil,
+
+        GroupRoles:          groupRoles(cfg, dbCfg),
+        CreateMissingRoles:  cfg.SyncPolicy.CreateMissingRoles,
+        GroupRoleAttributes: cfg.SyncPolicy.GroupRoleAttributes,
+    }
+
+    log.Printf("Phase 1 [%s]: Filtering all LDAP users...", dbCfg.Alias)
+    var unresolved []plan.UnresolvedGroup
+    for _, roleMap := range dbCfg.Roles {
+        if !opts.targets(roleMap.LDAPGroupCN) {
+            continue
+        }
+        // The members of an unreadable group are missing from desired.Users too, so they
+        // would look like users who left LDAP. Its role is left unchanged and nothing
+        // is deprovisioned in this database.
+        group := groups[roleMap.LDAPGroupCN]
+        if group.err != nil {
+            log.Printf("    ERROR fetching LDAP members for '%s': %v", roleMap.LDAPGroupCN, group.err)
+            if roleMap.Privileges != nil {
+                log.Printf("    WARNING [%s]: Privileges of role '%s' are not reconciled because '%s' could not be resolved.", dbCfg.Alias, roleMap.PostgresRole, roleMap.LDAPGroupCN)
+            }
+            unresolved = append(unresolved, plan.UnresolvedGroup{
+                LDAPGroup:         roleMap.LDAPGroupCN,
+                Role:              roleMap.PostgresRole,
+                Error:             group.err.Error(),
+                PrivilegesSkipped: roleMap.Privileges != nil,
+            })
+            desired.SkipDeprovision = true
+            continue
+        }
+
+        // A role that several LDAP users map to may still belong to one of them, so
+        // nothing is dropped while a collision is unresolved.
+        if len(group.collisions) > 0 && !desired.SkipDeprovision {
+            log.Printf("    WARNING [%s]: role name collisions %v in '%s'; deprovisioning is skipped.", dbCfg.Alias, group.collisions, roleMap.LDAPGroupCN)
+            desired.SkipDeprovision = true
+        }
+
+        // The scope applies to the role name, after the username rules.
+        filteredMembers := make(map[string]plan.Source)
+        for _, user := range group.users {
+            if userScope.Match(user.role) {
+                src := plan.Source{LDAPGroup: roleMap.LDAPGroupCN, DN: user.DN, Username: user.Username, ValidUntil: user.ValidUntil}
+                filteredMembers[user.role] = src
+                if _, seen := desired.Users[user.role]; !seen {
+                    desired.Users[user.role] = src
+                }
+            }
+        }
+        desired.Roles[roleMap.PostgresRole] = plan.RoleTarget{LDAPGroup: roleMap.LDAPGroupCN, Members: filteredMembers, Privileges: roleMap.Privileges}
+    }
+
+    // Attribute policies depend on every group of a user, so they are only reconciled
+    // with the complete LDAP view. VALID UNTIL depends on the user alone.
+    desired.ManageValidUntil = cfg.LDAP.ValidUntilAttribute != ""
+    if hasAttributePolicy(cfg, dbCfg) {
+        if opts.onlyGroups == nil && len(unresolved) == 0 {
+            desired.Attributes = userAttributes(cfg.SyncPolicy.UserAttributes, dbCfg.Roles, desired)
+            desired.ManagedParameters = managedParameters(cfg.SyncPolicy.UserAttributes, dbCfg.Roles)
+        } else if opts.onlyGroups == nil {
+            log.Printf("WARNING [%s]: Role attributes are not reconciled because some LDAP groups could not be resolved.", dbCfg.Alias)
+        }
+    }
+
+    // Compute the full change set for this DB before touching anything.
+    planCtx, cancelPlan := context.WithTimeout(ctx, 60*time.Second)
+    dbPlan, err := pgClient.Plan(planCtx, dbCfg.Alias, desired)
+    cancelPlan()
+    if err != nil {
+        return nil, fmt.Errorf("failed to plan changes: %w", err)
+    }
+    dbPlan.UnresolvedGroups = unresolved
+
+    var errs []error
+    if len(unresolved) > 0 {
+        cns := make([]string, 0, len(unresolved))
+        for _, g := range unresolved {
+            cns = append(cns, g.LDAPGroup)
+        }
+        log.Printf("WARNING [%s]: LDAP groups %v could not be resolved; their roles are left unchanged and deprovisioning is skipped.", dbCfg.Alias, cns)
+        errs = append(errs, fmt.Errorf("unresolvable LDAP groups %v, deprovisioning skipped", cns))
+    }
+
+    // An empty or truncated LDAP answer must not revoke everyone, so nothing is changed
+    // in a database whose plan exceeds the safety limits.
+    if err := dbPlan.CheckLimits(cfg.SyncPolicy.Safety); err != nil {
+        if !opts.force {
+            return dbPlan, errors.Join(append(errs, fmt.Errorf("%w; no changes applied, rerun with --force if intended", err))...)
+        }
+        log.Printf("WARNING [%s]: %v; applying anyway because of --force.", dbCfg.Alias, err)
+    }
+
+    if opts.dryRun {
+        return dbPlan, errors.Join(errs...)
+    }
+
+    // Now, run a single transaction to create all missing users.
+    provCtx, cancelProv := context.WithTimeout(ctx, 60*time.Second)
+    log.Printf("Phase 1 [%s]: Ensuring all valid users exist in PostgreSQL...", dbCfg.Alias)
+    err = pgClient.EnsureUsersExist(provCtx, dbPlan)
+    cancelProv()
+    if err != nil {
+        return dbPlan, errors.Join(append(errs, fmt.Errorf("user provisioning failed, membership sync skipped: %w", err))...)
+    }
+    metrics.AddChanges(dbCfg.Alias, metrics.ActionRoleCreated, len(dbPlan.GroupRolesCreated)+len(dbPlan.RolesCreated))
+    metrics.AddChanges(dbCfg.Alias, metrics.ActionGrant, len(dbPlan.RolesCreated))
+    metrics.AddChanges(dbCfg.Alias, metrics.ActionEnable, len(dbPlan.Enables))
+    metrics.AddChanges(dbCfg.Alias, metrics.ActionAttribute, len(dbPlan.Attributes))
+    log.Printf("Phase 1 [%s]: User provisioning complete.", dbCfg.Alias)
+
+    // == Phase 2: Membership Sync ==
+    // The privileges of the mapped roles are applied first, so new members get them at once.
+    if len(dbPlan.Privileges) > 0 {
+        log.Printf("Phase 2 [%s]: Synchronizing privileges of mapped roles...", dbCfg.Alias)
+        privCtx, cancelPriv := context.WithTimeout(ctx, 60*time.Second)
+        if err := pgClient.SyncPrivileges(privCtx, dbPlan); err != nil {
+            log.Printf("    ERROR [%s]: Failed to sync privileges: %v", dbCfg.Alias, err)
+            errs = append(errs, fmt.Errorf("privilege sync failed: %w", err))
+        } else {
+            metrics.AddChanges(dbCfg.Alias, metrics.ActionPrivilege, len(dbPlan.Privileges))
+        }
+        cancelPriv()
+    }
+
+    // A failure on one role is recorded but does not stop the remaining roles.
+    log.Printf("Phase 2 [%s]: Synchronizing group memberships...", dbCfg.Alias)
+    for _, pgRole := range dbPlan.GrantedRoles() {
+        if opts.stopping() {
+            return dbPlan, errors.Join(append(errs, errInterrupted)...)
+        }
+        log.Printf("--> [%s] Syncing membership for: [%s]", dbCfg.Alias, pgRole)
+        syncCtx, cancelSync := context.WithTimeout(ctx, 30*time.Second)
+        err = pgClient.SyncRoleMembership(syncCtx, dbPlan, pgRole)
+        if err != nil {
+            log.Printf("    ERROR [%s]: Failed to sync role membership: %v", dbCfg.Alias, err)
+            errs = append(errs, fmt.Errorf("membership sync for role '%s' failed: %w", pgRole, err))
+        } else {
+            log.Printf("    SUCCESS [%s]: PostgreSQL role '%s' is synchronized.", dbCfg.Alias, pgRole)
+            grants, revokes := dbPlan.MembershipChanges(pgRole)
+            metrics.AddChanges(dbCfg.Alias, metrics.ActionGrant, len(grants))
+            metrics.AddChanges(dbCfg.Alias, metrics.ActionRevoke, len(revokes))
+        }
+        cancelSync()
+    }
+    log.Printf("Phase 2 [%s]: Membership sync complete.", dbCfg.Alias)
+
+    // == Phase 3: Deprovisioning ==
+    // Without the complete LDAP view (a targeted pass, an unresolved group or a name
+    // collision), nothing is deprovisioned.
+    if desired.SkipDeprovision {
+        return dbPlan, errors.Join(errs...)
+    }
+    if opts.stopping() {
+        return dbPlan, errors.Join(append(errs, errInterrupted)...)
+    }
+    deprovisionCtx, cancelDeprov := context.WithTimeout(ctx, 30*time.Second)
+    err = pgClient.DeprovisionUsers(deprovisionCtx, dbPlan)
+    cancelDeprov()
+    if err != nil {
+        log.Printf("ERROR [%s]: Failed to deprovision users: %v", dbCfg.Alias, err)
+        return dbPlan, errors.Join(append(errs, fmt.Errorf("deprovisioning failed: %w", err))...)
+    }
+    metrics.AddChanges(dbCfg.Alias, metrics.ActionDisable, len(dbPlan.Disables))
+    if !dbCfg.Deprovision.ReleasesOwned() {
+        metrics.AddChanges(dbCfg.Alias, metrics.ActionDrop, len(dbPlan.Drops))
+        log.Printf("Phase 3 [%s]: Deprovisioning complete.", dbCfg.Alias)
+        return dbPlan, errors.Join(errs...)
+    }
+
+    // Releasing the objects of a role visits every database of the cluster and may
+    // reassign or drop many objects, so each role gets a deadline of its own.
+    for i := range dbPlan.Drops {
+        if opts.stopping() {
+            return dbPlan, errors.Join(append(errs, errInterrupted)...)
+        }
+        change := &dbPlan.Drops[i]
+        log.Printf("    RELEASING objects of stale user: %s", change.Role)
+        dropCtx, cancelDrop := context.WithTimeout(ctx, 10*time.Minute)
+        err := pgClient.ReleaseAndDrop(dropCtx, dbPlan, change)
+        cancelDrop()
+        if err != nil {
+            log.Printf("    ERROR: Failed to drop user '%s': %v", change.Role, err)
+            errs = append(errs, fmt.Errorf("deprovisioning failed: user '%s': %w", change.Role, err))
+            continue
+        }
+        log.Printf("    SUCCESS: Dropped user '%s'.", change.Role)
+        metrics.AddChanges(dbCfg.Alias, metrics.ActionDrop, 1)
+    }
+    log.Printf("Phase 3 [%s]: Deprovisioning complete.", dbCfg.Alias)
+    return dbPlan, errors.Join(errs...)
+}
+
+// hasAttributePolicy reports whether any user attribute policy applies to the database.
+func hasAttributePolicy(cfg *config.Config, dbCfg config.DatabaseConfig) bool {
+    if cfg.SyncPolicy.UserAttributes != nil {
+        return true
+    }
+    return slices.ContainsFunc(dbCfg.Roles, func(roleMap config.RoleMap) bool { return roleMap.UserAttributes != nil })
+}
+
+// managedParameters returns the names of the parameters set by the default attribute
+// policy or the policy of any mapped role, sorted.
+func managedParameters(defaults *config.RoleAttributes, roles []config.RoleMap) []string {
+    var names []string
+    add := func(policy *config.RoleAttributes) {
+        if policy == nil {
+            return
+        }
+        for name := range policy.Parameters {
+            if !slices.Contains(names, name) {
+                names = append(names, name)
+            }
+        }
+    }
+    add(defaults)
+    for _, roleMap := range roles {
+        add(roleMap.UserAttributes)
+    }
+    slices.Sort(names)
+    return names
+}
+
+// userAttributes merges, for every desired user, the default attribute policy with the
+// policies of the mapped roles the user is a member of. The most permissive connection
+// limit and the union of the attributes win; a parameter set by several roles takes the
+// value of the first in configuration order, then the default.
+func userAttributes(defaults *config.RoleAttributes, roles []config.RoleMap, desired plan.Desired) map[string]config.RoleAttributes {
+    merged := make(map[string]config.RoleAttributes, len(desired.Users))
+    for user := range desired.Users {
+        var policy config.RoleAttributes
+        policy.Parameters = make(map[string]string)
+        var groupLimit *int
+        for _, roleMap := range roles {
+            if _, member := desired.Roles[roleMap.PostgresRole].Members[user]; !member || roleMap.UserAttributes == nil {
+                continue
+            }
+            group := roleMap.UserAttributes
+            if limit := group.ConnectionLimit; limit != nil {
+                if groupLimit == nil || *groupLimit != -1 && (*limit == -1 || *limit > *groupLimit) {
+                    groupLimit = limit
+                }
+            }
+            policy.Attributes = append(policy.Attributes, group.Attributes...)
+            for name, value := range group.Parameters {
+                if _, set := policy.Parameters[name]; !set {
+                    policy.Parameters[name] = value
+                }
+            }
+        }
+        policy.ConnectionLimit = groupLimit
+        if defaults != nil {
+            if policy.ConnectionLimit == nil {
+                policy.ConnectionLimit = defaults.ConnectionLimit
+            }
+            policy.Attributes = append(policy.Attributes, defaults.Attributes...)
+            for name, value := range defaults.Parameters {
+                if _, set := policy.Parameters[name]; !set {
+                    policy.Parameters[name] = value
+                }
+            }
+        }
+        merged[user] = policy
+    }
+    return merged
+}
+
+// pgConnectTimeout bounds how long a worker waits for a database connection.
+const pgConnectTimeout = 30 * time.Second
+
+// pgClientCache hands out PostgreSQL clients per database alias. In daemon mode the
+// clients are kept open between passes so connection pools stay warm; otherwise each
+// client is closed as soon as its database has been synchronized.
+type pgClientCache struct {
+    keepWarm bool
+    mu       sync.Mutex
+    clients  map[string]*pgClientEntry
+}
+
+// pgClientEntry is a client that is connected, or being connected, for one alias.
+// ready is closed once the connection attempt has finished; client and err are only
+// read after that.
+type pgClientEntry struct {
+    ready  chan struct{}
+    client *postgres.Client
+    err    error
+}
+
+func newPgClientCache(keepWarm bool) *pgClientCache {
+    return &pgClientCache{
+        keepWarm: keepWarm,
+        clients:  make(map[string]*pgClientEntry),
+    }
+}
+
+// get returns a connected client for the database, reusing a warm one if available.
+// The connection is made outside the lock, so a slow or unreachable server only holds
+// up the callers asking for that alias; a failed attempt is forgotten so the next call
+// retries.
+func (c *pgClientCache) get(ctx context.Context, dbCfg config.DatabaseConfig) (*postgres.Client, error) {
+    c.mu.Lock()
+    entry, ok := c.clients[dbCfg.Alias]
+    if !ok {
+        entry = &pgClientEntry{ready: make(chan struct{})}
+        c.clients[dbCfg.Alias] = entry
+    }
+    c.mu.Unlock()
+
+    if ok {
+        select {
+        case <-entry.ready:
+            return entry.client, entry.err
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+    }
+
+    connectCtx, cancel := context.WithTimeout(ctx, pgConnectTimeout)
+    defer cancel()
+    client := postgres.NewClient(dbCfg.Postgres)
+    if err := client.Connect(connectCtx); err != nil {
+        entry.err = err
+        c.mu.Lock()
+        delete(c.clients, dbCfg.Alias)
+        c.mu.Unlock()
+    } else {
+        entry.client = client
+    }
+    close(entry.ready)
+    return entry.client, entry.err
+}
+
+// release hands a client back after its database has been synchronized.
+func (c *pgClientCache) release(alias string) {
+    if c.keepWarm {
+        return
+    }
+    c.mu.Lock()
+    entry, ok := c.clients[alias]
+    if ok {
+        delete(c.clients, alias)
+    }
+    c.mu.Unlock()
+
+    if ok {
+        <-entry.ready
+        if entry.client != nil {
+            entry.client.Close()
+        }
+    }
+}
+
+// closeAll closes every open client, e.g. on shutdown or after a config reload.
+// Connection attempts still in progress are waited for.
+func (c *pgClientCache) closeAll() {
+    c.mu.Lock()
+    entries := c.clients
+    c.clients = make(map[string]*pgClientEntry)
+    c.mu.Unlock()
+
+    for _, entry := range entries {
+        <-entry.ready
+        if entry.client != nil {
+            entry.client.Close()
+        }
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "maps"
+    "slices"
+    "testing"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/ldap"
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
+)
+
+// roleNames returns the role names of the users of a group, sorted.
+func roleNames(group groupResult) []string {
+    var names []string
+    for _, user := range group.users {
+        names = append(names, user.role)
+    }
+    slices.Sort(names)
+    return names
+}
+
+func TestMapUsernamesRejectsCollisions(t *testing.T) {
+    rules := config.UsernameRules{StripDomain: true, Lowercase: true}
+    groups := map[string]groupResult{
+        "admins": {members: []ldap.Member{
+            {DN: "cn=jdoe,ou=corp,dc=example,dc=org", Username: "JDoe@corp.example.org"},
+            {DN: "cn=asmith,ou=corp,dc=example,dc=org", Username: "asmith@corp.example.org"},
+        }},
+        "readers": {members: []ldap.Member{
+            {DN: "cn=jdoe,ou=partners,dc=example,dc=org", Username: "jdoe@partner.example.com"},
+            // The same user as in admins, with a differently cased DN: not a collision.
+            {DN: "CN=asmith,OU=corp,DC=example,DC=org", Username: "asmith@corp.example.org"},
+        }},
+    }
+
+    mapUsernames(rules, groups)
+
+    for cn, want := range map[string][]string{"admins": {"asmith"}, "readers": {"asmith"}} {
+        group := groups[cn]
+        if got := roleNames(group); !slices.Equal(got, want) {
+            t.Errorf("group %s: users %v, want %v", cn, got, want)
+        }
+        if !slices.Equal(group.collisions, []string{"jdoe"}) {
+            t.Errorf("group %s: collisions %v, want [jdoe]", cn, group.collisions)
+        }
+    }
+}
+
+func TestMapUsernamesWithinGroup(t *testing.T) {
+    rules := config.UsernameRules{Lowercase: true}
+    groups := map[string]groupResult{
+        "admins": {members: []ldap.Member{
+            {DN: "uid=JDoe,ou=a,dc=example,dc=org", Username: "JDoe"},
+            {DN: "uid=jdoe,ou=b,dc=example,dc=org", Username: "jdoe"},
+            {DN: "uid=bob,ou=a,dc=example,dc=org", Username: "Bob"},
+        }},
+    }
+
+    mapUsernames(rules, groups)
+
+    group := groups["admins"]
+    if got := roleNames(group); !slices.Equal(got, []string{"bob"}) {
+        t.Errorf("users %v, want [bob]", got)
+    }
+    if !slices.Equal(group.collisions, []string{"jdoe"}) {
+        t.Errorf("collisions %v, want [jdoe]", group.collisions)
+    }
+}
+
+func TestUserAttributesMergeOrder(t *testing.T) {
+    limit := func(n int) *int { return &n }
+    defaults := &config.RoleAttributes{
+        ConnectionLimit: limit(2),
+        Parameters:      map[string]string{"work_mem": "4MB", "statement_timeout": "1min"},
+    }
+    roles := []config.RoleMap{
+        {PostgresRole: "analysts", UserAttributes: &config.RoleAttributes{
+            ConnectionLimit: limit(5),
+            Attributes:      []string{"CREATEDB"},
+            Parameters:      map[string]string{"work_mem": "64MB"},
+        }},
+        {PostgresRole: "engineers", UserAttributes: &config.RoleAttributes{
+            ConnectionLimit: limit(10),
+            Attributes:      []string{"CREATEROLE"},
+            Parameters:      map[string]string{"work_mem": "1GB", "search_path": "eng, public"},
+        }},
+        {PostgresRole: "admins", UserAttributes: &config.RoleAttributes{ConnectionLimit: limit(-1)}},
+        {PostgresRole: "readers"}, // no policy of its own
+    }
+    members := func(users ...string) plan.RoleTarget {
+        target := plan.RoleTarget{Members: make(map[string]plan.Source)}
+        for _, user := range users {
+            target.Members[user] = plan.Source{}
+        }
+        return target
+    }
+    desired := plan.Desired{
+        Users: map[string]plan.Source{"alice": {}, "bob": {}, "carol": {}},
+        Roles: map[string]plan.RoleTarget{
+            "analysts":  members("alice", "bob"),
+            "engineers": members("alice"),
+            "admins":    members("bob"),
+            "readers":   members("carol"),
+        },
+    }
+
+    got := userAttributes(defaults, roles, desired)
+
+    tests := []struct {
+        user       string
+        limit      int
+        attributes []string
+        parameters map[string]string
+    }{
+        {
+            // The highest group limit wins; parameters come from the first role in
+            // configuration order, then the default.
+            user:       "alice",
+            limit:      10,
+            attributes: []string{"CREATEDB", "CREATEROLE"},
+            parameters: map[string]string{"work_mem": "64MB", "search_path": "eng, public", "statement_timeout": "1min"},
+        },
+        {
+            // No limit is the most permissive, whatever the order.
+            user:       "bob",
+            limit:      -1,
+            attributes: []string{"CREATEDB"},
+            parameters: map[string]string{"work_mem": "64MB", "statement_timeout": "1min"},
+        },
+        {
+            // Without a group policy, the default applies.
+            user:       "carol",
+            limit:      2,
+            parameters: map[string]string{"work_mem": "4MB", "statement_timeout": "1min"},
+        },
+    }
+    for _, tt := range tests {
+        policy, ok := got[tt.user]
+        if !ok {
+            t.Errorf("%s: no policy", tt.user)
+            continue
+        }
+        if policy.ConnectionLimit == nil || *policy.ConnectionLimit != tt.limit {
+            t.Errorf("%s: connection limit %v, want %d", tt.user, policy.ConnectionLimit, tt.limit)
+        }
+        attributes := slices.Clone(policy.Attributes)
+        slices.Sort(attributes)
+        if !slices.Equal(attributes, tt.attributes) {
+            t.Errorf("%s: attributes %v, want %v", tt.user, attributes, tt.attributes)
+        }
+        if !maps.Equal(policy.Parameters, tt.parameters) {
+            t.Errorf("%s: parameters %v, want %v", tt.user, policy.Parameters, tt.parameters)
+        }
+    }
+}
+
+func TestUserAttributesWithoutDefault(t *testing.T) {
+    desired := plan.Desired{
+        Users: map[string]plan.Source{"alice": {}},
+        Roles: map[string]plan.RoleTarget{"readers": {Members: map[string]plan.Source{"alice": {}}}},
+    }
+    roles := []config.RoleMap{{PostgresRole: "readers"}}
+
+    policy := userAttributes(nil, roles, desired)["alice"]
+    if policy.ConnectionLimit != nil || len(policy.Attributes) != 0 || len(policy.Parameters) != 0 {
+        t.Errorf("policy = %+v, want an empty policy", policy)
+    }
+}
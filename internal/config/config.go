@@ -1,7 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -9,6 +15,147 @@ import (
 type SyncPolicy struct {
     AllowedUserPrefixes  []string `yaml:"allowed_user_prefixes"`
     DefaultPostgresGroup string   `yaml:"default_postgres_group"`
+    // Concurrency is the maximum number of databases synchronized in parallel.
+    Concurrency          int      `yaml:"concurrency"`
+    // UsernameRules turn LDAP usernames into PostgreSQL role names.
+    UsernameRules        UsernameRules `yaml:"username_rules"`
+    // UserScope selects managed role names with patterns, in addition to AllowedUserPrefixes.
+    UserScope            UserScope     `yaml:"user_scope"`
+    // Ownership marks the roles created by the sync, so only those are ever revoked or dropped.
+    Ownership            OwnershipConfig `yaml:"ownership"`
+    // Safety limits how much access a single run may remove.
+    Safety               SafetyConfig    `yaml:"safety"`
+    // CreateMissingRoles creates default_postgres_group and the mapped roles when they
+    // do not exist, with GroupRoleAttributes. Otherwise a missing one fails the database.
+    CreateMissingRoles   bool                `yaml:"create_missing_roles"`
+    GroupRoleAttributes  GroupRoleAttributes `yaml:"group_role_attributes"`
+    // UserAttributes is the attribute policy of every managed user role. Members of a
+    // mapped group also get the policy of its RoleMap.
+    UserAttributes       *RoleAttributes     `yaml:"user_attributes"`
+}
+
+// RoleAttributes is an attribute policy for user roles, reconciled on every run.
+// SUPERUSER and REPLICATION are never granted, whatever the configuration says.
+type RoleAttributes struct {
+    ConnectionLimit *int              `yaml:"connection_limit"` // -1 for no limit
+    Attributes      []string          `yaml:"attributes"`       // CREATEDB, CREATEROLE
+    Parameters      map[string]string `yaml:"parameters"`       // ALTER ROLE ... SET, e.g. statement_timeout
+}
+
+// Role attributes a policy may grant, and those it may never grant.
+var (
+    RoleAttributeNames   = []string{"CREATEDB", "CREATEROLE"}
+    DeniedRoleAttributes = []string{"SUPERUSER", "REPLICATION"}
+)
+
+// parameterName matches the names accepted in RoleAttributes.Parameters, including
+// custom "extension.name" settings.
+var parameterName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// normalize validates the policy and upper-cases its attribute names.
+func (a *RoleAttributes) normalize() error {
+    if a.ConnectionLimit != nil && *a.ConnectionLimit < -1 {
+        return fmt.Errorf("invalid connection_limit %d: must be -1 (no limit) or more", *a.ConnectionLimit)
+    }
+    for i, attribute := range a.Attributes {
+        attribute = strings.ToUpper(strings.TrimSpace(attribute))
+        if slices.Contains(DeniedRoleAttributes, attribute) {
+            return fmt.Errorf("attribute %s can never be granted by the sync", attribute)
+        }
+        if !slices.Contains(RoleAttributeNames, attribute) {
+            return fmt.Errorf("invalid attribute '%s': must be one of %s", attribute, strings.Join(RoleAttributeNames, ", "))
+        }
+        a.Attributes[i] = attribute
+    }
+    // Parameter names are case-insensitive and stored lower-cased by PostgreSQL.
+    parameters := make(map[string]string, len(a.Parameters))
+    for name, value := range a.Parameters {
+        if !parameterName.MatchString(name) {
+            return fmt.Errorf("invalid parameter name '%s'", name)
+        }
+        parameters[strings.ToLower(name)] = value
+    }
+    a.Parameters = parameters
+    return nil
+}
+
+// GroupRoleAttributes are the attributes of group roles created by the sync, which are
+// always NOLOGIN.
+type GroupRoleAttributes struct {
+    Inherit         *bool `yaml:"inherit"`          // Default true
+    ConnectionLimit *int  `yaml:"connection_limit"` // Default -1 (no limit)
+}
+
+// Options returns the attributes as CREATE ROLE options.
+func (a GroupRoleAttributes) Options() string {
+    inherit := "INHERIT"
+    if a.Inherit != nil && !*a.Inherit {
+        inherit = "NOINHERIT"
+    }
+    limit := -1
+    if a.ConnectionLimit != nil {
+        limit = *a.ConnectionLimit
+    }
+    return fmt.Sprintf("NOLOGIN %s CONNECTION LIMIT %d", inherit, limit)
+}
+
+// SafetyConfig guards against an empty or truncated LDAP answer revoking everyone. A
+// database whose plan exceeds a limit is left untouched, unless the run is forced.
+type SafetyConfig struct {
+    PerRole     ChangeLimit `yaml:"per_role"`     // Revokes from a single mapped role
+    PerDatabase ChangeLimit `yaml:"per_database"` // Revokes across all roles, and users deprovisioned, in a database
+}
+
+// ChangeLimit caps the number of removals in a run. A zero field is not checked.
+type ChangeLimit struct {
+    Max     int     `yaml:"max"`     // Absolute number of removals
+    Percent float64 `yaml:"percent"` // Share of the current managed members, from 0 to 100
+}
+
+// Ways of marking the roles created by the sync.
+const (
+    OwnershipComment       = "comment"        // COMMENT ON ROLE
+    OwnershipSecurityLabel = "security_label" // SECURITY LABEL, needs a label provider
+)
+
+// OwnershipConfig selects how roles created by the sync are marked. With no marker,
+// every role in the user scope is treated as managed.
+type OwnershipConfig struct {
+    Marker   string `yaml:"marker"`   // "" (default), comment or security_label
+    Provider string `yaml:"provider"` // Security label provider, required for security_label
+}
+
+// UserScope lists the patterns selecting which role names the sync manages.
+// A role name is managed if it matches an include pattern (or an allowed prefix)
+// and no exclude pattern.
+type UserScope struct {
+    Include []Pattern `yaml:"include"`
+    Exclude []Pattern `yaml:"exclude"`
+}
+
+// Pattern matches role names with either a glob ('*' and '?' wildcards, anchored)
+// or a regular expression (unanchored). Exactly one of the two must be set.
+type Pattern struct {
+    Glob  string `yaml:"glob"`
+    Regex string `yaml:"regex"`
+}
+
+// UsernameRules are applied to every LDAP username, in field order, to derive its role name.
+// The result is always truncated to the PostgreSQL identifier limit.
+type UsernameRules struct {
+    StripDomain bool          `yaml:"strip_domain"` // Drop everything from the last '@', e.g. a userPrincipalName domain
+    Rewrite     []RewriteRule `yaml:"rewrite"`      // Regular expression replacements, applied in order
+    Lowercase   bool          `yaml:"lowercase"`
+    Prefix      string        `yaml:"prefix"`
+    Suffix      string        `yaml:"suffix"`
+}
+
+// RewriteRule replaces every match of Pattern with Replacement, which may refer to
+// capture groups as in regexp.Regexp.ReplaceAllString (e.g. "${1}_${2}").
+type RewriteRule struct {
+    Pattern     string         `yaml:"pattern"`
+    Replacement string         `yaml:"replacement"`
+    Regexp      *regexp.Regexp `yaml:"-"` // Compiled by Load
 }
 
 // Config is the top-level configuration struct.
@@ -16,13 +163,57 @@ type Config struct {
     SyncPolicy SyncPolicy       `yaml:"sync_policy"` // Add this line
     Databases  []DatabaseConfig `yaml:"databases"`
     LDAP       LDAPConfig       `yaml:"ldap"`
+    Daemon     DaemonConfig     `yaml:"daemon"`
+    Metrics    MetricsConfig    `yaml:"metrics"`
+}
+
+// MetricsConfig controls how Prometheus metrics are exposed.
+type MetricsConfig struct {
+	ListenAddress string `yaml:"listen_address"` // Address for the /metrics endpoint in serve mode, e.g. ":9187"
+	TextfilePath  string `yaml:"textfile_path"`  // File rewritten after every pass for node_exporter's textfile collector
+}
+
+// DaemonConfig holds the scheduling settings for the long-running `serve` mode.
+type DaemonConfig struct {
+	Interval time.Duration `yaml:"interval"` // Time between the end of one pass and the start of the next
+	Jitter   time.Duration `yaml:"jitter"`   // Random delay of up to this duration added to every interval
+	Watch    WatchConfig   `yaml:"watch"`
+}
+
+// WatchConfig enables event-driven sync through an LDAP Content Synchronization
+// (syncrepl, RFC 4533) persistent search.
+type WatchConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Debounce time.Duration `yaml:"debounce"` // How long to collect changes before a targeted sync (default 5s)
 }
 
 // DatabaseConfig holds all settings for a single PostgreSQL instance and its roles.
 type DatabaseConfig struct {
-	Alias    string       `yaml:"alias"`
-	Postgres PostgresConn `yaml:"postgres"`
-	Roles    []RoleMap    `yaml:"roles"`
+	Alias       string            `yaml:"alias"`
+	Postgres    PostgresConn      `yaml:"postgres"`
+	Roles       []RoleMap         `yaml:"roles"`
+	Deprovision DeprovisionConfig `yaml:"deprovision"`
+}
+
+// Deprovisioning strategies for users that left every mapped LDAP group.
+const (
+	DeprovisionDrop      = "drop"       // DROP ROLE immediately
+	DeprovisionDisable   = "disable"    // ALTER ROLE NOLOGIN, re-enabled if the user returns
+	DeprovisionDropAfter = "drop_after" // Disable first, drop once the grace period has passed
+)
+
+// DeprovisionConfig selects what happens to the roles of users who are no longer in LDAP.
+type DeprovisionConfig struct {
+	Strategy    string        `yaml:"strategy"`     // drop (default), disable or drop_after
+	GracePeriod time.Duration `yaml:"grace_period"` // How long a role stays disabled before drop_after drops it
+	// Before a role is dropped, in every database of the cluster:
+	ReassignOwnedTo string `yaml:"reassign_owned_to"` // REASSIGN OWNED BY the role TO this role
+	DropOwned       bool   `yaml:"drop_owned"`        // DROP OWNED BY the role (objects left and privileges)
+}
+
+// ReleasesOwned reports whether the objects of a role are released before it is dropped.
+func (d DeprovisionConfig) ReleasesOwned() bool {
+	return d.ReassignOwnedTo != "" || d.DropOwned
 }
 
 // PostgresConn holds the connection details for a PostgreSQL database.
@@ -39,6 +230,86 @@ type PostgresConn struct {
 type RoleMap struct {
 	PostgresRole  string `yaml:"postgres_role"`
 	LDAPGroupCN   string `yaml:"ldap_group_cn"`
+	// Privileges of the role itself, reconciled on every run. Nil leaves them unmanaged.
+	Privileges    *Privileges `yaml:"privileges"`
+	// UserAttributes is the attribute policy of the members' user roles, merged with
+	// sync_policy.user_attributes.
+	UserAttributes *RoleAttributes `yaml:"user_attributes"`
+}
+
+// Privileges declares what a mapped role may do in its database. The privileges on the
+// database and on every listed schema are reconciled: missing ones are granted and
+// others revoked. Schemas that are not listed are left alone.
+type Privileges struct {
+	Database []string           `yaml:"database"` // CONNECT, CREATE, TEMPORARY
+	Schemas  []SchemaPrivileges `yaml:"schemas"`
+}
+
+// SchemaPrivileges declares the privileges of a role on a schema and its tables.
+type SchemaPrivileges struct {
+	Name          string   `yaml:"name"`
+	Schema        []string `yaml:"schema"`         // USAGE, CREATE
+	Tables        []string `yaml:"tables"`         // On every existing table and view of the schema
+	DefaultTables []string `yaml:"default_tables"` // On tables created later by DefaultOwner
+	DefaultOwner  string   `yaml:"default_owner"`  // Role whose new tables get DefaultTables; defaults to the connecting user
+}
+
+// privilegeTypes lists the privileges that may be granted on each kind of object.
+var privilegeTypes = map[string][]string{
+	"database": {"CONNECT", "CREATE", "TEMPORARY"},
+	"schema":   {"USAGE", "CREATE"},
+	"table":    {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"},
+}
+
+// normalize validates and normalizes every privilege list in place.
+func (p *Privileges) normalize() error {
+	var err error
+	if p.Database, err = normalizePrivileges("database", p.Database); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for i := range p.Schemas {
+		schema := &p.Schemas[i]
+		if schema.Name == "" {
+			return fmt.Errorf("privileges.schemas: name is required")
+		}
+		if seen[schema.Name] {
+			return fmt.Errorf("privileges.schemas: schema '%s' is listed twice", schema.Name)
+		}
+		seen[schema.Name] = true
+		if schema.Schema, err = normalizePrivileges("schema", schema.Schema); err != nil {
+			return err
+		}
+		if schema.Tables, err = normalizePrivileges("table", schema.Tables); err != nil {
+			return err
+		}
+		if schema.DefaultTables, err = normalizePrivileges("table", schema.DefaultTables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizePrivileges upper-cases privilege names, expands ALL and TEMP, removes
+// duplicates and checks that each applies to the kind of object.
+func normalizePrivileges(kind string, privileges []string) ([]string, error) {
+	allowed := privilegeTypes[kind]
+	var normalized []string
+	for _, privilege := range privileges {
+		privilege = strings.ToUpper(strings.TrimSpace(privilege))
+		switch {
+		case privilege == "ALL" || privilege == "ALL PRIVILEGES":
+			normalized = append(normalized, allowed...)
+		case privilege == "TEMP" && kind == "database":
+			normalized = append(normalized, "TEMPORARY")
+		case slices.Contains(allowed, privilege):
+			normalized = append(normalized, privilege)
+		default:
+			return nil, fmt.Errorf("invalid %s privilege '%s': must be one of %s or ALL", kind, privilege, strings.Join(allowed, ", "))
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
 }
 
 // LDAPConfig holds the settings for connecting to the LDAP server.
@@ -50,11 +321,54 @@ type LDAPConfig struct {
 	BaseDN            string `yaml:"base_dn"`
 	GroupSearchBase   string `yaml:"group_search_base"`
 	GroupObjectClass  string `yaml:"group_object_class"`
+	GroupObjectClasses []string `yaml:"group_object_classes"` // objectClasses that mark a member as a nested group
+	MembershipAttribute string `yaml:"membership_attribute"`   // member (default), uniqueMember or memberUid
+	MembershipValueType string `yaml:"membership_value_type"`  // dn (default) or uid
 	UserSearchBase    string `yaml:"user_search_base"`
+	UsernameAttribute string   `yaml:"username_attribute"`  // Attribute holding the username (default uid)
+	UserObjectClasses []string `yaml:"user_object_classes"` // If set, a user must have one of these objectClasses
+	UserFilter        string   `yaml:"user_filter"`         // Optional LDAP filter a user must match, e.g. to exclude disabled accounts
+	SkipInactiveAccounts bool  `yaml:"skip_inactive_accounts"` // Treat disabled, locked and expired accounts as absent
+	MissingGroupAsEmpty bool   `yaml:"missing_group_as_empty"` // Treat a mapped group that is not found as having no members
+	ValidUntilAttribute string `yaml:"valid_until_attribute"` // Account expiry copied to VALID UNTIL, e.g. accountExpires or shadowExpire
+	// Deprecated: UserObjectClass was used as the username attribute; use UsernameAttribute.
 	UserObjectClass   string `yaml:"user_object_class"`
 	UseTLS            bool   `yaml:"use_tls"`
     SkipTLSVerify     bool   `yaml:"skip_tls_verify"`
 	CACertPath        string `yaml:"ca_cert_path"`
+	MembershipStrategy string `yaml:"membership_strategy"` // recursive (default), in_chain or member_of
+	MemberBatchSize   int    `yaml:"member_batch_size"`   // Member lookups pipelined per round trip by the recursive strategy (default 100)
+	PageSize          uint32 `yaml:"page_size"`           // Entries per page for subtree searches (RFC 2696, default 500)
+	Incremental       IncrementalConfig `yaml:"incremental"`
+}
+
+// Types of the values held by the membership attribute.
+const (
+	// MembershipValueDN values are member DNs (member, uniqueMember).
+	MembershipValueDN = "dn"
+	// MembershipValueUID values are plain usernames (posixGroup memberUid).
+	MembershipValueUID = "uid"
+)
+
+// Strategies for resolving the members of a group, including nested groups.
+const (
+	// MembershipRecursive reads the member attribute of every group and looks up each member.
+	MembershipRecursive = "recursive"
+	// MembershipInChain uses the Active Directory LDAP_MATCHING_RULE_IN_CHAIN to fetch all
+	// transitive members of a group in a single search.
+	MembershipInChain = "in_chain"
+	// MembershipMemberOf finds the direct members of a group with one memberOf search,
+	// as maintained by the OpenLDAP memberof overlay.
+	MembershipMemberOf = "member_of"
+)
+
+// IncrementalConfig enables skipping LDAP groups that have not changed since the previous run.
+type IncrementalConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	StateFile          string        `yaml:"state_file"`           // Where resolved groups are kept between runs
+	ChangeAttribute    string        `yaml:"change_attribute"`     // modifyTimestamp (default), entryCSN (OpenLDAP) or uSNChanged (AD)
+	UseContextCSN      bool          `yaml:"use_context_csn"`      // Skip all group checks when the OpenLDAP contextCSN of base_dn is unchanged
+	FullResyncInterval time.Duration `yaml:"full_resync_interval"` // Forces a full walk of every group this often (default 24h)
 }
 
 // Load reads the configuration from a YAML file and overrides specific fields
@@ -83,5 +397,158 @@ func Load(path string) (*Config, error) {
 		cfg.LDAP.BindPassword = ldapPassword
 	}
 
+	for i := range cfg.Databases {
+		deprovision := &cfg.Databases[i].Deprovision
+		switch deprovision.Strategy {
+		case "":
+			deprovision.Strategy = DeprovisionDrop
+		case DeprovisionDrop, DeprovisionDisable:
+		case DeprovisionDropAfter:
+			if deprovision.GracePeriod <= 0 {
+				return nil, fmt.Errorf("database '%s': deprovision.grace_period is required for the %s strategy", cfg.Databases[i].Alias, DeprovisionDropAfter)
+			}
+		default:
+			return nil, fmt.Errorf("database '%s': invalid deprovision.strategy '%s': must be %s, %s or %s",
+				cfg.Databases[i].Alias, deprovision.Strategy, DeprovisionDrop, DeprovisionDisable, DeprovisionDropAfter)
+		}
+	}
+
+	for name, limit := range map[string]ChangeLimit{"per_role": cfg.SyncPolicy.Safety.PerRole, "per_database": cfg.SyncPolicy.Safety.PerDatabase} {
+		if limit.Max < 0 || limit.Percent < 0 || limit.Percent > 100 {
+			return nil, fmt.Errorf("invalid sync_policy.safety.%s: max must not be negative and percent must be between 0 and 100", name)
+		}
+	}
+
+	for i := range cfg.Databases {
+		for j := range cfg.Databases[i].Roles {
+			roleMap := &cfg.Databases[i].Roles[j]
+			if roleMap.UserAttributes != nil {
+				if err := roleMap.UserAttributes.normalize(); err != nil {
+					return nil, fmt.Errorf("database '%s', role '%s': user_attributes: %w", cfg.Databases[i].Alias, roleMap.PostgresRole, err)
+				}
+			}
+			if roleMap.Privileges == nil {
+				continue
+			}
+			if err := roleMap.Privileges.normalize(); err != nil {
+				return nil, fmt.Errorf("database '%s', role '%s': %w", cfg.Databases[i].Alias, roleMap.PostgresRole, err)
+			}
+		}
+	}
+
+	if cfg.SyncPolicy.UserAttributes != nil {
+		if err := cfg.SyncPolicy.UserAttributes.normalize(); err != nil {
+			return nil, fmt.Errorf("sync_policy.user_attributes: %w", err)
+		}
+	}
+
+	if limit := cfg.SyncPolicy.GroupRoleAttributes.ConnectionLimit; limit != nil && *limit < -1 {
+		return nil, fmt.Errorf("invalid sync_policy.group_role_attributes.connection_limit %d: must be -1 (no limit) or more", *limit)
+	}
+
+	// Databases are synchronized one at a time unless told otherwise.
+	if cfg.SyncPolicy.Concurrency < 1 {
+		cfg.SyncPolicy.Concurrency = 1
+	}
+
+	switch cfg.LDAP.MembershipStrategy {
+	case "":
+		cfg.LDAP.MembershipStrategy = MembershipRecursive
+	case MembershipRecursive, MembershipInChain, MembershipMemberOf:
+	default:
+		return nil, fmt.Errorf("invalid ldap.membership_strategy '%s': must be one of %s, %s or %s",
+			cfg.LDAP.MembershipStrategy, MembershipRecursive, MembershipInChain, MembershipMemberOf)
+	}
+
+	for _, patterns := range [][]Pattern{cfg.SyncPolicy.UserScope.Include, cfg.SyncPolicy.UserScope.Exclude} {
+		for _, p := range patterns {
+			if (p.Glob == "") == (p.Regex == "") {
+				return nil, fmt.Errorf("invalid sync_policy.user_scope pattern: exactly one of glob or regex must be set")
+			}
+			if p.Regex != "" {
+				if _, err := regexp.Compile(p.Regex); err != nil {
+					return nil, fmt.Errorf("invalid sync_policy.user_scope regex '%s': %w", p.Regex, err)
+				}
+			}
+		}
+	}
+
+	switch cfg.SyncPolicy.Ownership.Marker {
+	case "", OwnershipComment:
+	case OwnershipSecurityLabel:
+		if cfg.SyncPolicy.Ownership.Provider == "" {
+			return nil, fmt.Errorf("sync_policy.ownership.provider is required for the %s marker", OwnershipSecurityLabel)
+		}
+	default:
+		return nil, fmt.Errorf("invalid sync_policy.ownership.marker '%s': must be %s or %s",
+			cfg.SyncPolicy.Ownership.Marker, OwnershipComment, OwnershipSecurityLabel)
+	}
+
+	for i, rule := range cfg.SyncPolicy.UsernameRules.Rewrite {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sync_policy.username_rules.rewrite pattern '%s': %w", rule.Pattern, err)
+		}
+		cfg.SyncPolicy.UsernameRules.Rewrite[i].Regexp = re
+	}
+
+	if cfg.LDAP.UsernameAttribute == "" {
+		cfg.LDAP.UsernameAttribute = cfg.LDAP.UserObjectClass
+	}
+	if cfg.LDAP.UsernameAttribute == "" {
+		cfg.LDAP.UsernameAttribute = "uid"
+	}
+	if f := cfg.LDAP.UserFilter; f != "" && !(strings.HasPrefix(f, "(") && strings.HasSuffix(f, ")")) {
+		cfg.LDAP.UserFilter = "(" + f + ")"
+	}
+
+	if cfg.LDAP.MembershipAttribute == "" {
+		cfg.LDAP.MembershipAttribute = "member"
+	}
+	switch cfg.LDAP.MembershipValueType {
+	case "":
+		cfg.LDAP.MembershipValueType = MembershipValueDN
+	case MembershipValueDN:
+	case MembershipValueUID:
+		if cfg.LDAP.MembershipStrategy != MembershipRecursive {
+			return nil, fmt.Errorf("ldap.membership_value_type '%s' requires the %s membership strategy", MembershipValueUID, MembershipRecursive)
+		}
+	default:
+		return nil, fmt.Errorf("invalid ldap.membership_value_type '%s': must be %s or %s",
+			cfg.LDAP.MembershipValueType, MembershipValueDN, MembershipValueUID)
+	}
+	if len(cfg.LDAP.GroupObjectClasses) == 0 {
+		cfg.LDAP.GroupObjectClasses = []string{"group", "groupOfNames"}
+		if cfg.LDAP.GroupObjectClass != "" && !slices.ContainsFunc(cfg.LDAP.GroupObjectClasses, func(oc string) bool {
+			return strings.EqualFold(oc, cfg.LDAP.GroupObjectClass)
+		}) {
+			cfg.LDAP.GroupObjectClasses = append(cfg.LDAP.GroupObjectClasses, cfg.LDAP.GroupObjectClass)
+		}
+	}
+
+	if cfg.LDAP.MemberBatchSize < 1 {
+		cfg.LDAP.MemberBatchSize = 100
+	}
+	if cfg.LDAP.PageSize == 0 {
+		cfg.LDAP.PageSize = 500
+	}
+
+	if cfg.LDAP.Incremental.ChangeAttribute == "" {
+		cfg.LDAP.Incremental.ChangeAttribute = "modifyTimestamp"
+	}
+	if cfg.LDAP.Incremental.StateFile == "" {
+		cfg.LDAP.Incremental.StateFile = "/var/lib/pg-ldap-sync/ldap-state.json"
+	}
+	if cfg.LDAP.Incremental.FullResyncInterval <= 0 {
+		cfg.LDAP.Incremental.FullResyncInterval = 24 * time.Hour
+	}
+
+	if cfg.Daemon.Interval <= 0 {
+		cfg.Daemon.Interval = 15 * time.Minute
+	}
+	if cfg.Daemon.Watch.Debounce <= 0 {
+		cfg.Daemon.Watch.Debounce = 5 * time.Second
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,106 @@
+// internal/config/config_test.go
+
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "testing"
+)
+
+// load writes yml to a temporary config.yml and loads it.
+func load(t *testing.T, yml string) (*Config, error) {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "config.yml")
+    if err := os.WriteFile(path, []byte(yml), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return Load(path)
+}
+
+func TestLoadRejectsDeniedRoleAttributes(t *testing.T) {
+    tests := map[string]string{
+        "default policy SUPERUSER": `
+sync_policy:
+  user_attributes:
+    attributes: ["CREATEDB", "SUPERUSER"]
+`,
+        "default policy lower-case replication": `
+sync_policy:
+  user_attributes:
+    attributes: [" replication "]
+`,
+        "role policy SUPERUSER": `
+databases:
+  - alias: main
+    roles:
+      - ldap_group_cn: admins
+        postgres_role: ldap_admins
+        user_attributes:
+          attributes: ["superuser"]
+`,
+    }
+    for name, yml := range tests {
+        t.Run(name, func(t *testing.T) {
+            _, err := load(t, yml)
+            if err == nil || !strings.Contains(err.Error(), "can never be granted") {
+                t.Fatalf("Load = %v, want an error rejecting the attribute", err)
+            }
+        })
+    }
+}
+
+func TestLoadNormalizesRoleAttributes(t *testing.T) {
+    cfg, err := load(t, `
+sync_policy:
+  user_attributes:
+    connection_limit: 5
+    attributes: ["createdb", " CreateRole"]
+    parameters:
+      Statement_Timeout: "5min"
+      search_path: '"$user", public'
+`)
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+    policy := cfg.SyncPolicy.UserAttributes
+    if want := []string{"CREATEDB", "CREATEROLE"}; !slices.Equal(policy.Attributes, want) {
+        t.Errorf("attributes = %v, want %v", policy.Attributes, want)
+    }
+    if got := policy.Parameters["statement_timeout"]; got != "5min" {
+        t.Errorf("statement_timeout = %q, want 5min", got)
+    }
+    if got := policy.Parameters["search_path"]; got != `"$user", public` {
+        t.Errorf("search_path = %q, want %q", got, `"$user", public`)
+    }
+}
+
+func TestLoadRejectsInvalidRoleAttributes(t *testing.T) {
+    tests := map[string]string{
+        "unknown attribute": `
+sync_policy:
+  user_attributes:
+    attributes: ["BYPASSRLS"]
+`,
+        "connection limit below -1": `
+sync_policy:
+  user_attributes:
+    connection_limit: -2
+`,
+        "invalid parameter name": `
+sync_policy:
+  user_attributes:
+    parameters:
+      "work_mem; DROP ROLE x": "4MB"
+`,
+    }
+    for name, yml := range tests {
+        t.Run(name, func(t *testing.T) {
+            if _, err := load(t, yml); err == nil {
+                t.Fatal("Load accepted an invalid user_attributes policy")
+            }
+        })
+    }
+}
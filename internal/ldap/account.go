@@ -0,0 +1,120 @@
+// internal/ldap/account.go
+
+package ldap
+
+import (
+    "log"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+// Attributes describing the state of an account. Active Directory uses
+// userAccountControl and accountExpires; OpenLDAP uses the ppolicy overlay's
+// pwdAccountLockedTime and the shadowAccount shadowExpire.
+const (
+    attrUserAccountControl   = "userAccountControl"
+    attrAccountExpires       = "accountExpires"
+    attrPwdAccountLockedTime = "pwdAccountLockedTime"
+    attrShadowExpire         = "shadowExpire"
+)
+
+// uacAccountDisable is the ACCOUNTDISABLE flag of userAccountControl.
+const uacAccountDisable = 0x2
+
+// fileTimeToUnix is the number of seconds between the Windows FILETIME epoch
+// (1601-01-01), used by accountExpires, and the Unix epoch.
+const fileTimeToUnix = 11644473600
+
+// userAttributes returns the attributes to request for entries that may be users.
+func (c *Client) userAttributes() []string {
+    attributes := []string{"objectClass", c.config.UsernameAttribute}
+    if c.config.SkipInactiveAccounts {
+        attributes = append(attributes, attrUserAccountControl, attrAccountExpires, attrPwdAccountLockedTime, attrShadowExpire)
+    }
+    if c.config.ValidUntilAttribute != "" {
+        attributes = append(attributes, c.config.ValidUntilAttribute)
+    }
+    return attributes
+}
+
+// accountExpiry reads the expiry of an account from attribute: accountExpires as a
+// FILETIME, shadowExpire as days since 1970, anything else as a GeneralizedTime
+// (e.g. pwdEndTime). It returns nil if the account never expires or the value
+// cannot be parsed.
+func accountExpiry(entry *ldap.Entry, attribute string) *time.Time {
+    value := entry.GetAttributeValue(attribute)
+    if value == "" {
+        return nil
+    }
+
+    var expiry time.Time
+    switch {
+    case strings.EqualFold(attribute, attrAccountExpires):
+        expires, err := strconv.ParseInt(value, 10, 64)
+        if err != nil || expires <= 0 || expires == math.MaxInt64 {
+            return nil
+        }
+        expiry = time.Unix(expires/10_000_000-fileTimeToUnix, 0)
+    case strings.EqualFold(attribute, attrShadowExpire):
+        days, err := strconv.ParseInt(value, 10, 64)
+        if err != nil || days < 0 {
+            return nil
+        }
+        expiry = time.Unix(days*24*60*60, 0)
+    default:
+        // Fractional seconds after the seconds field are accepted by time.Parse.
+        t, err := time.Parse("20060102150405Z0700", value)
+        if err != nil {
+            log.Printf("Warning: could not parse %s '%s' of '%s': %v", attribute, value, entry.DN, err)
+            return nil
+        }
+        expiry = t
+    }
+    expiry = expiry.UTC()
+    return &expiry
+}
+
+// inactiveAt returns the earliest expiry of an active account, from accountExpires and
+// shadowExpire, or nil if it never expires.
+func inactiveAt(entry *ldap.Entry) *time.Time {
+    var earliest *time.Time
+    for _, attribute := range []string{attrAccountExpires, attrShadowExpire} {
+        if expiry := accountExpiry(entry, attribute); expiry != nil && (earliest == nil || expiry.Before(*earliest)) {
+            earliest = expiry
+        }
+    }
+    return earliest
+}
+
+// inactiveReason returns why the account of a user entry cannot be used at now,
+// or "" if it is active. Attributes that are absent or cannot be parsed are ignored.
+func inactiveReason(entry *ldap.Entry, now time.Time) string {
+    if uac, err := strconv.ParseInt(entry.GetAttributeValue(attrUserAccountControl), 10, 64); err == nil && uac&uacAccountDisable != 0 {
+        return "account disabled"
+    }
+
+    // accountExpires counts 100ns intervals since 1601; 0 and the maximum value mean "never".
+    if expires, err := strconv.ParseInt(entry.GetAttributeValue(attrAccountExpires), 10, 64); err == nil && expires > 0 && expires != math.MaxInt64 {
+        if !now.Before(time.Unix(expires/10_000_000-fileTimeToUnix, 0)) {
+            return "account expired"
+        }
+    }
+
+    // The ppolicy overlay sets pwdAccountLockedTime while the account is locked; it is
+    // removed when the account is unlocked. "000001010000Z" marks a permanent lock.
+    if entry.GetAttributeValue(attrPwdAccountLockedTime) != "" {
+        return "account locked"
+    }
+
+    // shadowExpire counts days since 1970-01-01; -1 means "never".
+    if days, err := strconv.ParseInt(entry.GetAttributeValue(attrShadowExpire), 10, 64); err == nil && days >= 0 {
+        if !now.Before(time.Unix(days*24*60*60, 0)) {
+            return "account expired"
+        }
+    }
+    return ""
+}
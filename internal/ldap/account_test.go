@@ -0,0 +1,107 @@
+// internal/ldap/account_test.go
+
+package ldap
+
+import (
+    "testing"
+    "time"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+const testDN = "uid=jdoe,ou=users,dc=example,dc=org"
+
+// newYear is 2025-01-01T00:00:00Z, as a FILETIME (100ns since 1601) and in days since 1970.
+var newYear = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+    newYearFileTime = "133801632000000000"
+    newYearDays     = "20089"
+)
+
+func TestAccountExpiry(t *testing.T) {
+    tests := []struct {
+        name      string
+        attribute string
+        value     string
+        want      *time.Time
+    }{
+        {name: "accountExpires as FILETIME", attribute: "accountExpires", value: newYearFileTime, want: &newYear},
+        {name: "accountExpires attribute name is case-insensitive", attribute: "accountexpires", value: newYearFileTime, want: &newYear},
+        {name: "accountExpires 0 never expires", attribute: "accountExpires", value: "0"},
+        {name: "accountExpires max never expires", attribute: "accountExpires", value: "9223372036854775807"},
+        {name: "accountExpires unparsable", attribute: "accountExpires", value: "soon"},
+        {name: "shadowExpire as days since 1970", attribute: "shadowExpire", value: newYearDays, want: &newYear},
+        {name: "shadowExpire -1 never expires", attribute: "shadowExpire", value: "-1"},
+        {name: "GeneralizedTime", attribute: "pwdEndTime", value: "20250101000000Z", want: &newYear},
+        {name: "GeneralizedTime with offset and fraction", attribute: "pwdEndTime", value: "20250101020000.5+0200", want: timePtr(newYear.Add(500 * time.Millisecond))},
+        {name: "GeneralizedTime unparsable", attribute: "pwdEndTime", value: "2025-01-01"},
+        {name: "absent", attribute: "pwdEndTime"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            attributes := map[string][]string{}
+            if tt.value != "" {
+                attributes[tt.attribute] = []string{tt.value}
+            }
+            got := accountExpiry(ldap.NewEntry(testDN, attributes), tt.attribute)
+            switch {
+            case tt.want == nil && got != nil:
+                t.Errorf("accountExpiry = %v, want nil", got)
+            case tt.want != nil && (got == nil || !got.Equal(*tt.want)):
+                t.Errorf("accountExpiry = %v, want %v", got, tt.want)
+            case got != nil && got.Location() != time.UTC:
+                t.Errorf("accountExpiry = %v, want UTC", got)
+            }
+        })
+    }
+}
+
+func TestInactiveReason(t *testing.T) {
+    before := newYear.Add(-time.Second)
+    tests := []struct {
+        name       string
+        attributes map[string][]string
+        now        time.Time
+        want       string
+    }{
+        {name: "no account attributes", now: newYear},
+        {name: "normal account", attributes: map[string][]string{"userAccountControl": {"512"}}, now: newYear},
+        {name: "ACCOUNTDISABLE bit", attributes: map[string][]string{"userAccountControl": {"514"}}, now: newYear, want: "account disabled"},
+        {name: "other bits only", attributes: map[string][]string{"userAccountControl": {"66048"}}, now: newYear},
+        {name: "accountExpires 0 is never", attributes: map[string][]string{"accountExpires": {"0"}}, now: newYear},
+        {name: "accountExpires max is never", attributes: map[string][]string{"accountExpires": {"9223372036854775807"}}, now: newYear},
+        {name: "accountExpires not yet reached", attributes: map[string][]string{"accountExpires": {newYearFileTime}}, now: before},
+        {name: "accountExpires reached", attributes: map[string][]string{"accountExpires": {newYearFileTime}}, now: newYear, want: "account expired"},
+        {name: "pwdAccountLockedTime set", attributes: map[string][]string{"pwdAccountLockedTime": {"20240601120000Z"}}, now: newYear, want: "account locked"},
+        {name: "pwdAccountLockedTime permanent lock", attributes: map[string][]string{"pwdAccountLockedTime": {"000001010000Z"}}, now: newYear, want: "account locked"},
+        {name: "shadowExpire -1 is never", attributes: map[string][]string{"shadowExpire": {"-1"}}, now: newYear},
+        {name: "shadowExpire not yet reached", attributes: map[string][]string{"shadowExpire": {newYearDays}}, now: before},
+        {name: "shadowExpire reached", attributes: map[string][]string{"shadowExpire": {newYearDays}}, now: newYear, want: "account expired"},
+        {name: "unparsable values are ignored", attributes: map[string][]string{"userAccountControl": {"x"}, "accountExpires": {"x"}, "shadowExpire": {"x"}}, now: newYear},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := inactiveReason(ldap.NewEntry(testDN, tt.attributes), tt.now); got != tt.want {
+                t.Errorf("inactiveReason = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestInactiveAtTakesEarliestExpiry(t *testing.T) {
+    entry := ldap.NewEntry(testDN, map[string][]string{
+        "accountExpires": {newYearFileTime},
+        "shadowExpire":   {"20088"},
+    })
+    want := newYear.AddDate(0, 0, -1)
+    if got := inactiveAt(entry); got == nil || !got.Equal(want) {
+        t.Errorf("inactiveAt = %v, want %v", got, want)
+    }
+}
+
+func timePtr(t time.Time) *time.Time {
+    return &t
+}
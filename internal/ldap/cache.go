@@ -0,0 +1,117 @@
+// internal/ldap/cache.go
+
+package ldap
+
+import (
+    "sort"
+    "strings"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+// groupNode is the direct membership of a single LDAP group, classified into
+// users and nested groups. Transitive membership is assembled from these nodes.
+// Fields are exported so nodes can be persisted between runs in incremental mode.
+type groupNode struct {
+    Marker string   `json:"marker,omitempty"` // value of the change attribute when the node was read
+    Users  []Member `json:"users"`
+    Groups []string `json:"groups"` // DNs of nested groups
+    // Skipped holds the DNs of users left out as inactive, so that a change to one of
+    // them, such as its reactivation, is noticed like a change to any other member.
+    Skipped []string `json:"skipped,omitempty"`
+    // Transitive is set when Users already holds every member, nested groups included,
+    // as resolved by the in_chain strategy. Such nodes carry no change marker.
+    Transitive bool `json:"transitive,omitempty"`
+}
+
+// resolutionCache memoizes group lookups for the duration of a sync run, so a
+// group (or a nested group shared by several groups) is only walked once no
+// matter how many roles and databases reference it.
+type resolutionCache struct {
+    groupDNs map[string]string          // group CN -> group DN
+    nodes    map[string]*groupNode      // normalized group DN -> direct membership
+    closures map[string]map[string]bool // group CN -> normalized DNs of every group walked to resolve it
+}
+
+func newResolutionCache() *resolutionCache {
+    return &resolutionCache{
+        groupDNs: make(map[string]string),
+        nodes:    make(map[string]*groupNode),
+        closures: make(map[string]map[string]bool),
+    }
+}
+
+// ResetCache discards all cached group resolutions. Call it at the start of every
+// sync run so changes made in LDAP since the previous run are picked up.
+// In incremental mode, it also decides whether this run reuses unchanged groups
+// from the previous run or performs a forced full resync.
+func (c *Client) ResetCache() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.cache = newResolutionCache()
+    if c.config.Incremental.Enabled {
+        c.beginIncrementalRun()
+    }
+}
+
+// Invalidate drops every cached group that is, or directly contains, one of the changed
+// entries, and returns the CNs of the resolved groups that may be affected by them, in
+// sorted order. Refetching those CNs re-reads only the invalidated groups.
+func (c *Client) Invalidate(changedDNs []string) []string {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    changed := make(map[string]bool, len(changedDNs))
+    for _, dn := range changedDNs {
+        changed[normalizeDN(dn)] = true
+    }
+
+    stale := make(map[string]bool)
+    for key, node := range c.cache.nodes {
+        // A transitive node can be affected by a change to any nested group, which
+        // it does not track, so it is re-read after every change.
+        if changed[key] || (node.Transitive && len(changed) > 0) {
+            stale[key] = true
+            continue
+        }
+        for _, user := range node.Users {
+            if changed[normalizeDN(user.DN)] {
+                stale[key] = true
+                break
+            }
+        }
+        for _, dn := range node.Skipped {
+            if changed[normalizeDN(dn)] {
+                stale[key] = true
+                break
+            }
+        }
+    }
+    for key := range stale {
+        delete(c.cache.nodes, key)
+        if c.state != nil {
+            delete(c.state.Groups, key)
+        }
+    }
+
+    var affected []string
+    for cn, walked := range c.cache.closures {
+        for key := range walked {
+            if stale[key] {
+                affected = append(affected, cn)
+                break
+            }
+        }
+    }
+    sort.Strings(affected)
+    return affected
+}
+
+// normalizeDN returns a canonical form of dn suitable for use as a map key.
+func normalizeDN(dn string) string {
+    parsed, err := ldap.ParseDN(dn)
+    if err != nil {
+        return strings.ToLower(dn)
+    }
+    return strings.ToLower(parsed.String())
+}
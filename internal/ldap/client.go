@@ -3,37 +3,78 @@
 package ldap
 
 import (
+    "context"
     "crypto/tls"
     "crypto/x509"
+    "errors"
     "fmt"
     "log"
     "os"
+    "slices"
     "strings"
+    "sync"
+    "time"
 
     "github.com/Dataloh/pg-ldap-sync/internal/config"
     "github.com/go-ldap/ldap/v3"
 )
 
+// Member is a user resolved from an LDAP group.
+type Member struct {
+    Username string `json:"username"`
+    DN       string `json:"dn"`
+    // ValidUntil is the account expiry read from valid_until_attribute, nil if the
+    // account never expires or the attribute is not configured.
+    ValidUntil *time.Time `json:"valid_until,omitempty"`
+    // InactiveAt is when the account expires, if skip_inactive_accounts is set and the
+    // account has an expiry date. A group reused from a previous run is read again once
+    // it has passed.
+    InactiveAt *time.Time `json:"inactive_at,omitempty"`
+}
+
+// ErrGroupNotFound is returned when the group search succeeds but finds no group with
+// the requested CN, as opposed to a search that fails.
+var ErrGroupNotFound = errors.New("LDAP group not found")
+
+// Client is safe for concurrent use; group resolutions are serialized on the
+// underlying connection.
 type Client struct {
     Conn   *ldap.Conn
     config config.LDAPConfig
+    mu     sync.Mutex
+    cache  *resolutionCache
+    state  *incrementalState // nil unless incremental mode is enabled
 }
 
 func NewClient(cfg config.LDAPConfig) *Client {
     return &Client{
         config: cfg,
+        cache:  newResolutionCache(),
     }
 }
 
 func (c *Client) Connect() error {
+    conn, err := c.dial()
+    if err != nil {
+        return err
+    }
+    c.Conn = conn
+
+    log.Println("Successfully connected and bound to LDAP server.")
+    return nil
+}
+
+// dial opens and binds a new connection using the client's configuration.
+func (c *Client) dial() (*ldap.Conn, error) {
     address := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
 
+    var conn *ldap.Conn
     if !c.config.UseTLS {
-        conn, err := ldap.Dial("tcp", address)
+        plainConn, err := ldap.Dial("tcp", address)
         if err != nil {
-            return fmt.Errorf("failed to dial LDAP server: %w", err)
+            return nil, fmt.Errorf("failed to dial LDAP server: %w", err)
         }
-        c.Conn = conn
+        conn = plainConn
     } else {
         tlsConfig := &tls.Config{
             ServerName: c.config.Host,
@@ -44,66 +85,88 @@ func (c *Client) Connect() error {
             certPool := x509.NewCertPool()
             ca, err := os.ReadFile(c.config.CACertPath)
             if err != nil {
-                return fmt.Errorf("could not read CA certificate from '%s': %w", c.config.CACertPath, err)
+                return nil, fmt.Errorf("could not read CA certificate from '%s': %w", c.config.CACertPath, err)
             }
             if ok := certPool.AppendCertsFromPEM(ca); !ok {
-                return fmt.Errorf("failed to append CA cert from '%s' to pool", c.config.CACertPath)
+                return nil, fmt.Errorf("failed to append CA cert from '%s' to pool", c.config.CACertPath)
             }
             tlsConfig.RootCAs = certPool
         } else if c.config.SkipTLSVerify {
             log.Println("Warning: No CA certificate provided. Using InsecureSkipVerify as a fallback.")
             tlsConfig.InsecureSkipVerify = true
         } else {
-            return fmt.Errorf("TLS is enabled, but no ca_cert_path was provided and skip_tls_verify is false")
+            return nil, fmt.Errorf("TLS is enabled, but no ca_cert_path was provided and skip_tls_verify is false")
         }
 
-        conn, err := ldap.DialTLS("tcp", address, tlsConfig)
+        tlsConn, err := ldap.DialTLS("tcp", address, tlsConfig)
         if err != nil {
-            return fmt.Errorf("failed to dial LDAPS server: %w", err)
+            return nil, fmt.Errorf("failed to dial LDAPS server: %w", err)
         }
-        c.Conn = conn
+        conn = tlsConn
     }
 
-    err := c.Conn.Bind(c.config.BindDN, c.config.BindPassword)
-    if err != nil {
-        return fmt.Errorf("failed to bind to LDAP server: %w", err)
+    if err := conn.Bind(c.config.BindDN, c.config.BindPassword); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("failed to bind to LDAP server: %w", err)
     }
-
-    log.Println("Successfully connected and bound to LDAP server.")
-    return nil
+    return conn, nil
 }
 
 func (c *Client) Close() {
     if c.Conn != nil {
         c.Conn.Close()
+        c.Conn = nil
         log.Println("LDAP connection closed.")
     }
 }
 
+// IsConnected reports whether the client holds a connection that is still usable.
+func (c *Client) IsConnected() bool {
+    return c.Conn != nil && !c.Conn.IsClosing()
+}
+
 // FetchGroupMembers is the public entry point for fetching all users from a group, including nested groups.
-func (c *Client) FetchGroupMembers(groupCN string) ([]string, error) {
+// A group that does not exist (the search succeeds but finds no entry) is an error like
+// any other lookup failure, unless missing_group_as_empty treats it as having no members.
+func (c *Client) FetchGroupMembers(groupCN string) ([]Member, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
     // First, find the full Distinguished Name (DN) of the starting group.
     groupDN, err := c.findGroupDN(groupCN)
+    if errors.Is(err, ErrGroupNotFound) && c.config.MissingGroupAsEmpty {
+        log.Printf("Warning: %v. Treating it as a group without members.", err)
+        c.cache.closures[groupCN] = map[string]bool{}
+        return []Member{}, nil
+    }
     if err != nil {
         return nil, err
     }
 
     // These maps will be populated by the recursive search.
-    // userIDs stores the final list of user UIDs, using a map to prevent duplicates.
-    userIDs := make(map[string]bool)
+    // userIDs stores the final list of users keyed by UID, using a map to prevent duplicates.
+    userIDs := make(map[string]Member)
     // processedGroups prevents infinite loops from circular group memberships.
     processedGroups := make(map[string]bool)
 
     // Start the recursive search.
-    log.Printf("Starting recursive member search for group: %s", groupCN)
-    if err := c.fetchMembersRecursive(groupDN, userIDs, processedGroups); err != nil {
-        return nil, fmt.Errorf("recursive search failed for group '%s': %w", groupCN, err)
+    if c.config.MembershipStrategy == config.MembershipInChain {
+        log.Printf("Starting in-chain member search for group: %s", groupCN)
+        if err := c.fetchMembersInChain(groupDN, userIDs, processedGroups); err != nil {
+            return nil, fmt.Errorf("in-chain search failed for group '%s': %w", groupCN, err)
+        }
+    } else {
+        log.Printf("Starting recursive member search for group: %s", groupCN)
+        if err := c.fetchMembersRecursive(groupDN, userIDs, processedGroups); err != nil {
+            return nil, fmt.Errorf("recursive search failed for group '%s': %w", groupCN, err)
+        }
     }
+    c.cache.closures[groupCN] = processedGroups
 
     // Convert the map keys to a slice for the return value.
-    finalUserList := make([]string, 0, len(userIDs))
-    for uid := range userIDs {
-        finalUserList = append(finalUserList, uid)
+    finalUserList := make([]Member, 0, len(userIDs))
+    for _, member := range userIDs {
+        finalUserList = append(finalUserList, member)
     }
 
     log.Printf("Found %d unique members in group '%s' and its subgroups.", len(finalUserList), groupCN)
@@ -111,13 +174,70 @@ func (c *Client) FetchGroupMembers(groupCN string) ([]string, error) {
 }
 
 // fetchMembersRecursive performs the actual work of expanding group memberships.
-func (c *Client) fetchMembersRecursive(groupDN string, userIDs map[string]bool, processedGroups map[string]bool) error {
+func (c *Client) fetchMembersRecursive(groupDN string, userIDs map[string]Member, processedGroups map[string]bool) error {
     // --- Loop prevention ---
-    if processedGroups[groupDN] {
+    key := normalizeDN(groupDN)
+    if processedGroups[key] {
         log.Printf("    (Skipping already processed group: %s)", groupDN)
         return nil
     }
-    processedGroups[groupDN] = true
+    processedGroups[key] = true
+
+    node, err := c.loadGroup(groupDN)
+    if err != nil {
+        return err
+    }
+
+    for _, user := range node.Users {
+        if _, seen := userIDs[user.Username]; !seen {
+            userIDs[user.Username] = user
+        }
+    }
+
+    for _, nestedDN := range node.Groups {
+        // --- RECURSIVE STEP ---
+        // A nested group that cannot be read leaves the member list incomplete, which
+        // must not be mistaken for its members having left.
+        if err := c.fetchMembersRecursive(nestedDN, userIDs, processedGroups); err != nil {
+            return fmt.Errorf("nested group '%s': %w", nestedDN, err)
+        }
+    }
+    return nil
+}
+
+// loadGroup returns the direct membership of a group, reading it from LDAP only
+// the first time the group is seen in the current run.
+func (c *Client) loadGroup(groupDN string) (*groupNode, error) {
+    key := normalizeDN(groupDN)
+    if node, ok := c.cache.nodes[key]; ok {
+        return node, nil
+    }
+    if node := c.unchangedGroup(groupDN); node != nil {
+        c.cache.nodes[key] = node
+        return node, nil
+    }
+
+    var node *groupNode
+    var err error
+    if c.config.MembershipStrategy == config.MembershipMemberOf {
+        node, err = c.searchDirectMembers(groupDN)
+    } else {
+        node, err = c.readDirectMembers(groupDN)
+    }
+    if err != nil {
+        return nil, err
+    }
+    c.cache.nodes[key] = node
+    return node, nil
+}
+
+// readDirectMembers reads the membership attribute of a group and looks up every member
+// to tell users from nested groups.
+func (c *Client) readDirectMembers(groupDN string) (*groupNode, error) {
+    attributes := []string{c.config.MembershipAttribute}
+    if c.state != nil {
+        attributes = append(attributes, c.config.Incremental.ChangeAttribute)
+    }
 
     // Search for the current group object to get its members.
     searchRequest := ldap.NewSearchRequest(
@@ -126,71 +246,120 @@ func (c *Client) fetchMembersRecursive(groupDN string, userIDs map[string]bool,
         ldap.NeverDerefAliases,
         0, 0, false,
         "(objectClass=*)", // A filter that will always match the object.
-        []string{"member"},
+        attributes,
         nil,
     )
 
     sr, err := c.Conn.Search(searchRequest)
     if err != nil {
-        return fmt.Errorf("LDAP search for group DN '%s' failed: %w", groupDN, err)
+        return nil, fmt.Errorf("LDAP search for group DN '%s' failed: %w", groupDN, err)
     }
     if len(sr.Entries) == 0 {
-        return fmt.Errorf("could not find group object for DN '%s'", groupDN)
+        return nil, fmt.Errorf("could not find group object for DN '%s'", groupDN)
     }
 
-    memberDNs := sr.Entries[0].GetAttributeValues("member")
-    if len(memberDNs) == 0 {
-        return nil // Group is empty, nothing more to do here.
+    node := &groupNode{}
+    if c.state != nil {
+        node.Marker = sr.Entries[0].GetAttributeValue(c.config.Incremental.ChangeAttribute)
     }
 
-    // --- Process each member ---
-    for _, memberDN := range memberDNs {
-        if memberDN == "" {
-            continue
-        }
-        // For each member, we need to find out what it is (a user or a group).
-        memberEntry, err := c.getObject(memberDN)
+    values, err := c.memberValues(sr.Entries[0])
+    if err != nil {
+        return nil, err
+    }
+
+    // posixGroup-style memberUid values are usernames; such groups cannot nest.
+    if c.config.MembershipValueType == config.MembershipValueUID {
+        usernames := slices.DeleteFunc(values, func(v string) bool { return v == "" })
+        users, err := c.findUsers(usernames)
         if err != nil {
-            log.Printf("Warning: Could not retrieve object for DN '%s': %v. Skipping.", memberDN, err)
-            continue
+            return nil, err
+        }
+        for _, userEntry := range users {
+            c.addMember(node, userEntry)
         }
+        return node, nil
+    }
 
-        objectClasses := memberEntry.GetAttributeValues("objectClass")
-        isGroup := false
-        for _, oc := range objectClasses {
-            // Check if the object is a group. Common objectClasses are 'group', 'groupOfNames'.
-            if strings.EqualFold(oc, "group") || strings.EqualFold(oc, "groupOfNames") {
-                isGroup = true
-                break
-            }
+    // --- Process each member ---
+    // For each member, we need to find out what it is (a user or a group).
+    var memberDNs []string
+    for _, memberDN := range values {
+        if memberDN = stripOptionalUID(memberDN); memberDN != "" {
+            memberDNs = append(memberDNs, memberDN)
         }
+    }
+    members, err := c.getObjects(memberDNs)
+    if err != nil {
+        return nil, err
+    }
+    for _, memberEntry := range members {
+        c.addMember(node, memberEntry)
+    }
+    return node, nil
+}
 
-        if isGroup {
-            // --- RECURSIVE STEP ---
-            // If it's a group, recurse into it.
-            log.Printf("    -> Found nested group, recursing into: %s", memberDN)
-            if err := c.fetchMembersRecursive(memberDN, userIDs, processedGroups); err != nil {
-                log.Printf("Warning: failed to process nested group '%s': %v", memberDN, err)
-            }
-        } else {
-            // --- BASE CASE ---
-            // If it's not a group, assume it's a user and try to get its 'uid'.
-            uid := memberEntry.GetAttributeValue(c.config.UserObjectClass)
-            if uid == "" {
-                log.Printf("Warning: Member with DN '%s' is not a group and has no '%s' attribute. Skipping.", memberDN, c.config.UserObjectClass)
-                continue
-            }
-            if !userIDs[uid] {
-                log.Printf("    -> Found user: %s", uid)
-                userIDs[uid] = true
+// stripOptionalUID removes the optional unique identifier that uniqueMember values
+// (Name and Optional UID syntax) may carry, e.g. "uid=jdoe,ou=users,dc=example,dc=org#'0101'B".
+func stripOptionalUID(value string) string {
+    if i := strings.LastIndex(value, "#'"); i > 0 && strings.HasSuffix(value, "'B") {
+        return value[:i]
+    }
+    return value
+}
+
+// addMember classifies an entry as a nested group or a user and adds it to node.
+// The entry must carry the objectClass and username attributes.
+func (c *Client) addMember(node *groupNode, memberEntry *ldap.Entry) {
+    if c.isGroupEntry(memberEntry) {
+        log.Printf("    -> Found nested group: %s", memberEntry.DN)
+        node.Groups = append(node.Groups, memberEntry.DN)
+        return
+    }
+
+    // --- BASE CASE ---
+    // If it's not a group, assume it's a user and try to get its 'uid'.
+    uid := memberEntry.GetAttributeValue(c.config.UsernameAttribute)
+    if uid == "" {
+        log.Printf("Warning: Member with DN '%s' is not a group and has no '%s' attribute. Skipping.", memberEntry.DN, c.config.UsernameAttribute)
+        return
+    }
+    if c.config.SkipInactiveAccounts {
+        if reason := inactiveReason(memberEntry, time.Now()); reason != "" {
+            log.Printf("    -> Skipping inactive user: %s (%s)", uid, reason)
+            node.Skipped = append(node.Skipped, memberEntry.DN)
+            return
+        }
+    }
+    log.Printf("    -> Found user: %s", uid)
+    member := Member{Username: uid, DN: memberEntry.DN}
+    if c.config.SkipInactiveAccounts {
+        member.InactiveAt = inactiveAt(memberEntry)
+    }
+    if c.config.ValidUntilAttribute != "" {
+        member.ValidUntil = accountExpiry(memberEntry, c.config.ValidUntilAttribute)
+    }
+    node.Users = append(node.Users, member)
+}
+
+// isGroupEntry reports whether an entry has one of the configured group objectClasses.
+func (c *Client) isGroupEntry(entry *ldap.Entry) bool {
+    for _, oc := range entry.GetAttributeValues("objectClass") {
+        for _, groupClass := range c.config.GroupObjectClasses {
+            if strings.EqualFold(oc, groupClass) {
+                return true
             }
         }
     }
-    return nil
+    return false
 }
 
 // findGroupDN locates the full DN of a group given its Common Name (CN).
 func (c *Client) findGroupDN(groupCN string) (string, error) {
+    if dn, ok := c.cache.groupDNs[groupCN]; ok {
+        return dn, nil
+    }
+
     searchRequest := ldap.NewSearchRequest(
         c.config.GroupSearchBase,
         ldap.ScopeWholeSubtree,
@@ -201,37 +370,105 @@ func (c *Client) findGroupDN(groupCN string) (string, error) {
         nil,
     )
 
-    sr, err := c.Conn.Search(searchRequest)
+    sr, err := c.Conn.SearchWithPaging(searchRequest, c.config.PageSize)
     if err != nil {
         return "", fmt.Errorf("LDAP search for group CN '%s' failed: %w", groupCN, err)
     }
     if len(sr.Entries) == 0 {
-        return "", fmt.Errorf("LDAP group with CN '%s' not found under search base '%s'", groupCN, c.config.GroupSearchBase)
+        return "", fmt.Errorf("%w: no group with CN '%s' under search base '%s'", ErrGroupNotFound, groupCN, c.config.GroupSearchBase)
     }
     if len(sr.Entries) > 1 {
         return "", fmt.Errorf("found multiple LDAP groups with CN '%s'", groupCN)
     }
+    c.cache.groupDNs[groupCN] = sr.Entries[0].DN
     return sr.Entries[0].DN, nil
 }
 
-// getObject retrieves a full LDAP entry for a given DN.
-func (c *Client) getObject(dn string) (*ldap.Entry, error) {
-    searchRequest := ldap.NewSearchRequest(
+// getObjects retrieves the LDAP entries for the given DNs. Lookups are sent in batches
+// of member_batch_size pipelined base searches, so each batch costs a single round trip
+// instead of one per DN. Only groups and entries matching the user filter are returned;
+// other DNs, and DNs that no longer exist, are logged and skipped. Any other lookup
+// failure is returned, as the member may still exist.
+func (c *Client) getObjects(dns []string) ([]*ldap.Entry, error) {
+    entries := make([]*ldap.Entry, 0, len(dns))
+    for start := 0; start < len(dns); start += c.config.MemberBatchSize {
+        batch := dns[start:min(start+c.config.MemberBatchSize, len(dns))]
+
+        ctx, cancel := context.WithCancel(context.Background())
+        responses := make([]ldap.Response, len(batch))
+        for i, dn := range batch {
+            responses[i] = c.Conn.SearchAsync(ctx, c.objectRequest(dn), 1)
+        }
+        for i, resp := range responses {
+            var entry *ldap.Entry
+            for resp.Next() {
+                if e := resp.Entry(); e != nil && entry == nil {
+                    entry = e
+                }
+            }
+            if err := resp.Err(); ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+                log.Printf("Warning: Member with DN '%s' does not exist. Skipping.", batch[i])
+                continue
+            } else if err != nil {
+                cancel()
+                return nil, fmt.Errorf("could not retrieve member '%s': %w", batch[i], err)
+            }
+            if entry == nil {
+                log.Printf("Warning: Member with DN '%s' is neither a group nor a user matching the user filter. Skipping.", batch[i])
+                continue
+            }
+            entries = append(entries, entry)
+        }
+        cancel()
+    }
+    return entries, nil
+}
+
+// findUsers looks up user entries by username, as held by memberUid. Usernames are
+// matched in batches of member_batch_size with one OR-filter search under the user
+// search base. Usernames without a matching user entry are logged and skipped; a failed
+// search is returned.
+func (c *Client) findUsers(usernames []string) ([]*ldap.Entry, error) {
+    attribute := c.config.UsernameAttribute
+    var entries []*ldap.Entry
+    for start := 0; start < len(usernames); start += c.config.MemberBatchSize {
+        batch := usernames[start:min(start+c.config.MemberBatchSize, len(usernames))]
+
+        var filter strings.Builder
+        filter.WriteString("(&" + c.userFilter() + "(|")
+        for _, username := range batch {
+            fmt.Fprintf(&filter, "(%s=%s)", attribute, ldap.EscapeFilter(username))
+        }
+        filter.WriteString("))")
+
+        found, err := c.searchMembers(c.config.UserSearchBase, filter.String())
+        if err != nil {
+            return nil, err
+        }
+
+        seen := make(map[string]bool, len(found))
+        for _, entry := range found {
+            seen[strings.ToLower(entry.GetAttributeValue(attribute))] = true
+        }
+        for _, username := range batch {
+            if !seen[strings.ToLower(username)] {
+                log.Printf("Warning: No user matching the user filter with %s '%s' found under '%s'. Skipping.", attribute, username, c.config.UserSearchBase)
+            }
+        }
+        entries = append(entries, found...)
+    }
+    return entries, nil
+}
+
+// objectRequest builds the base search used to classify a member entry.
+func (c *Client) objectRequest(dn string) *ldap.SearchRequest {
+    return ldap.NewSearchRequest(
         dn,
         ldap.ScopeBaseObject,
         ldap.NeverDerefAliases,
         0, 0, false,
-        "(objectClass=*)",
-        []string{"objectClass", c.config.UserObjectClass},
+        c.memberFilter(),
+        c.userAttributes(),
         nil,
     )
-
-    sr, err := c.Conn.Search(searchRequest)
-    if err != nil {
-        return nil, err
-    }
-    if len(sr.Entries) == 0 {
-        return nil, fmt.Errorf("object not found")
-    }
-    return sr.Entries[0], nil
 }
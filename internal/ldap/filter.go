@@ -0,0 +1,83 @@
+// internal/ldap/filter.go
+
+package ldap
+
+import (
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+// userFilter returns the LDAP filter an entry must match to count as a user: it must
+// carry the username attribute, have one of user_object_classes if any are configured,
+// and match user_filter if set.
+func (c *Client) userFilter() string {
+    var filter strings.Builder
+    filter.WriteString("(&")
+    fmt.Fprintf(&filter, "(%s=*)", c.config.UsernameAttribute)
+    if len(c.config.UserObjectClasses) > 0 {
+        filter.WriteString("(|")
+        for _, oc := range c.config.UserObjectClasses {
+            fmt.Fprintf(&filter, "(objectClass=%s)", ldap.EscapeFilter(oc))
+        }
+        filter.WriteString(")")
+    }
+    filter.WriteString(c.config.UserFilter)
+    filter.WriteString(")")
+    return filter.String()
+}
+
+// memberFilter returns the LDAP filter matching every entry that can be a group member:
+// a nested group or a user.
+func (c *Client) memberFilter() string {
+    var filter strings.Builder
+    filter.WriteString("(|")
+    for _, oc := range c.config.GroupObjectClasses {
+        fmt.Fprintf(&filter, "(objectClass=%s)", ldap.EscapeFilter(oc))
+    }
+    filter.WriteString(c.userFilter())
+    filter.WriteString(")")
+    return filter.String()
+}
+
+// restrictsUsers reports whether user_object_classes or user_filter may exclude members.
+func (c *Client) restrictsUsers() bool {
+    return len(c.config.UserObjectClasses) > 0 || c.config.UserFilter != ""
+}
+
+// reportNonUsers logs the entries matching filter under baseDN that are missing from
+// matched, i.e. members skipped because they are neither a group nor a matching user.
+// Search-based strategies only see matching entries, so this takes an extra search,
+// done only when users are restricted beyond the username attribute.
+func (c *Client) reportNonUsers(baseDN, filter string, matched []*ldap.Entry) {
+    if !c.restrictsUsers() {
+        return
+    }
+
+    searchRequest := ldap.NewSearchRequest(
+        baseDN,
+        ldap.ScopeWholeSubtree,
+        ldap.NeverDerefAliases,
+        0, 0, false,
+        filter,
+        []string{"1.1"}, // Only the DNs.
+        nil,
+    )
+    sr, err := c.Conn.SearchWithPaging(searchRequest, c.config.PageSize)
+    if err != nil {
+        log.Printf("Warning: could not list skipped members under '%s': %v", baseDN, err)
+        return
+    }
+
+    kept := make(map[string]bool, len(matched))
+    for _, entry := range matched {
+        kept[normalizeDN(entry.DN)] = true
+    }
+    for _, entry := range sr.Entries {
+        if !kept[normalizeDN(entry.DN)] {
+            log.Printf("Warning: Member with DN '%s' is neither a group nor a user matching the user filter. Skipping.", entry.DN)
+        }
+    }
+}
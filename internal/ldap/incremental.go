@@ -0,0 +1,256 @@
+// internal/ldap/incremental.go
+
+package ldap
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+// incrementalState is persisted between runs so that groups whose change attribute
+// (modifyTimestamp, entryCSN, uSNChanged, ...) has not moved can be reused without
+// walking their members again.
+type incrementalState struct {
+    LastFullSync time.Time             `json:"last_full_sync"`
+    LastRun      time.Time             `json:"last_run"` // start of the last run, full or not
+    ContextCSN   string                `json:"context_csn,omitempty"`
+    Groups       map[string]*groupNode `json:"groups"` // normalized group DN -> direct membership
+
+    // Decided at the start of every run; not persisted.
+    fullSync         bool
+    directoryChanged bool
+    pendingCSN       string
+    runStart         time.Time
+    changedEntries   map[string]bool // normalized DNs of entries modified since LastRun
+}
+
+// changeMargin is subtracted from the start of the last run when searching for modified
+// entries, as modifyTimestamp is set by the clock of the LDAP server.
+const changeMargin = 5 * time.Minute
+
+// beginIncrementalRun loads the previous state if needed and decides how this run
+// treats groups seen before. Must be called with c.mu held.
+func (c *Client) beginIncrementalRun() {
+    if c.state == nil {
+        state, err := loadIncrementalState(c.config.Incremental.StateFile)
+        if err != nil {
+            log.Printf("Warning: could not load incremental state from '%s', performing a full sync: %v", c.config.Incremental.StateFile, err)
+            state = &incrementalState{}
+        }
+        c.state = state
+    }
+    if c.state.Groups == nil {
+        c.state.Groups = make(map[string]*groupNode)
+    }
+
+    // Without the start of the last run, modified user entries cannot be found.
+    c.state.fullSync = c.state.LastRun.IsZero() || time.Since(c.state.LastFullSync) >= c.config.Incremental.FullResyncInterval
+    c.state.directoryChanged = true
+    c.state.pendingCSN = ""
+    c.state.runStart = time.Now().UTC()
+    c.state.changedEntries = nil
+
+    if c.state.fullSync {
+        log.Println("Incremental sync: performing periodic full resync of all groups.")
+        return
+    }
+
+    // contextCSN changes on every write to the directory, so when it has not moved
+    // every group can be reused without even checking its own change attribute.
+    if c.config.Incremental.UseContextCSN {
+        csn, err := c.readAttribute(c.config.BaseDN, "contextCSN")
+        if err != nil {
+            log.Printf("Warning: could not read contextCSN of '%s': %v", c.config.BaseDN, err)
+            return
+        }
+        c.state.pendingCSN = csn
+        if csn != "" && csn == c.state.ContextCSN {
+            log.Println("Incremental sync: contextCSN unchanged, reusing all groups from the previous run.")
+            c.state.directoryChanged = false
+        }
+    }
+
+    // Account state, usernames and valid_until_attribute live on the user entries, whose
+    // changes do not touch the groups containing them.
+    if c.state.directoryChanged {
+        changed, err := c.modifiedEntries(c.state.LastRun.Add(-changeMargin))
+        if err != nil {
+            log.Printf("Warning: could not search for user entries modified since the last run, performing a full sync: %v", err)
+            c.state.fullSync = true
+            return
+        }
+        c.state.changedEntries = changed
+    }
+}
+
+// modifiedEntries returns the normalized DNs of the entries under user_search_base (or
+// base_dn) whose modifyTimestamp is not older than since.
+func (c *Client) modifiedEntries(since time.Time) (map[string]bool, error) {
+    base := c.config.UserSearchBase
+    if base == "" {
+        base = c.config.BaseDN
+    }
+    searchRequest := ldap.NewSearchRequest(
+        base,
+        ldap.ScopeWholeSubtree,
+        ldap.NeverDerefAliases,
+        0, 0, false,
+        fmt.Sprintf("(modifyTimestamp>=%s)", since.UTC().Format("20060102150405Z")),
+        []string{"dn"},
+        nil,
+    )
+    sr, err := c.Conn.SearchWithPaging(searchRequest, c.config.PageSize)
+    if err != nil {
+        return nil, err
+    }
+    changed := make(map[string]bool, len(sr.Entries))
+    for _, entry := range sr.Entries {
+        changed[normalizeDN(entry.DN)] = true
+    }
+    return changed, nil
+}
+
+// membersChanged reports why a group stored by the previous run must be read again
+// although the group entry itself has not changed: one of its users was modified, or
+// the account of one of its users has expired since. It returns "" if neither applies.
+func (c *Client) membersChanged(node *groupNode) string {
+    now := time.Now()
+    for _, user := range node.Users {
+        if user.InactiveAt != nil && !now.Before(*user.InactiveAt) {
+            return "account of " + user.DN + " expired"
+        }
+        if c.state.changedEntries[normalizeDN(user.DN)] {
+            return "user " + user.DN + " modified"
+        }
+    }
+    for _, dn := range node.Skipped {
+        if c.state.changedEntries[normalizeDN(dn)] {
+            return "inactive user " + dn + " modified"
+        }
+    }
+    return ""
+}
+
+// unchangedGroup returns the group as stored by the previous run if it has not
+// changed since, or nil if it must be read from LDAP. Must be called with c.mu held.
+func (c *Client) unchangedGroup(groupDN string) *groupNode {
+    if c.state == nil || c.state.fullSync {
+        return nil
+    }
+    prev, ok := c.state.Groups[normalizeDN(groupDN)]
+    if !ok || prev.Marker == "" {
+        return nil
+    }
+    if reason := c.membersChanged(prev); reason != "" {
+        log.Printf("    (Group re-read, %s since last run: %s)", reason, groupDN)
+        return nil
+    }
+    if !c.state.directoryChanged {
+        return prev
+    }
+
+    marker, err := c.readAttribute(groupDN, c.config.Incremental.ChangeAttribute)
+    if err != nil || marker != prev.Marker {
+        return nil
+    }
+    log.Printf("    (Group unchanged since last run: %s)", groupDN)
+    return prev
+}
+
+// SaveState persists the groups resolved in this run for the next incremental run.
+// Groups that were not referenced in this run are dropped from the state. It is a
+// no-op unless incremental mode is enabled.
+func (c *Client) SaveState() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.state == nil {
+        return nil
+    }
+    // Only the first save of a full run records it: the daemon saves again after every
+    // targeted refresh, which does not re-read the other groups.
+    if c.state.fullSync {
+        c.state.LastFullSync = time.Now().UTC()
+        c.state.fullSync = false
+    }
+    if !c.state.runStart.IsZero() {
+        c.state.LastRun = c.state.runStart
+    }
+    if c.state.pendingCSN != "" {
+        c.state.ContextCSN = c.state.pendingCSN
+    }
+    c.state.Groups = c.cache.nodes
+    return saveIncrementalState(c.config.Incremental.StateFile, c.state)
+}
+
+// readAttribute reads a single (possibly operational) attribute of an entry.
+func (c *Client) readAttribute(dn, attribute string) (string, error) {
+    searchRequest := ldap.NewSearchRequest(
+        dn,
+        ldap.ScopeBaseObject,
+        ldap.NeverDerefAliases,
+        0, 0, false,
+        "(objectClass=*)",
+        []string{attribute},
+        nil,
+    )
+
+    sr, err := c.Conn.Search(searchRequest)
+    if err != nil {
+        return "", err
+    }
+    if len(sr.Entries) == 0 {
+        return "", fmt.Errorf("object not found")
+    }
+    // An entry may hold several values (e.g. one contextCSN per provider); any change
+    // to any of them counts as a change.
+    values := sr.Entries[0].GetAttributeValues(attribute)
+    sort.Strings(values)
+    return strings.Join(values, " "), nil
+}
+
+func loadIncrementalState(path string) (*incrementalState, error) {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return &incrementalState{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var state incrementalState
+    if err := json.Unmarshal(data, &state); err != nil {
+        return nil, err
+    }
+    return &state, nil
+}
+
+// saveIncrementalState writes the state atomically so a crash never leaves a truncated file.
+func saveIncrementalState(path string, state *incrementalState) error {
+    data, err := json.Marshal(state)
+    if err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+    if err != nil {
+        return err
+    }
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,95 @@
+// internal/ldap/memberof.go
+
+package ldap
+
+import (
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+// matchingRuleInChain is the Active Directory LDAP_MATCHING_RULE_IN_CHAIN. Applied to
+// memberOf, it matches every entry that is a member of the group, directly or through
+// any number of nested groups.
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// fetchMembersInChain resolves all transitive members of a group with a single subtree
+// search under the user search base. Nested groups are resolved by the server, so the
+// whole result is cached as one transitive node.
+func (c *Client) fetchMembersInChain(groupDN string, userIDs map[string]Member, processedGroups map[string]bool) error {
+    key := normalizeDN(groupDN)
+    processedGroups[key] = true
+
+    node, ok := c.cache.nodes[key]
+    if !ok || !node.Transitive {
+        var notGroups strings.Builder
+        for _, oc := range c.config.GroupObjectClasses {
+            fmt.Fprintf(&notGroups, "(!(objectClass=%s))", ldap.EscapeFilter(oc))
+        }
+        inChain := fmt.Sprintf("(memberOf:%s:=%s)", matchingRuleInChain, ldap.EscapeFilter(groupDN))
+        entries, err := c.searchMembers(c.config.UserSearchBase, "(&"+inChain+c.userFilter()+notGroups.String()+")")
+        if err != nil {
+            return err
+        }
+        c.reportNonUsers(c.config.UserSearchBase, "(&"+inChain+notGroups.String()+")", entries)
+        node = &groupNode{Transitive: true}
+        for _, entry := range entries {
+            c.addMember(node, entry)
+        }
+        c.cache.nodes[key] = node
+    }
+
+    for _, user := range node.Users {
+        if _, seen := userIDs[user.Username]; !seen {
+            userIDs[user.Username] = user
+        }
+    }
+    return nil
+}
+
+// searchDirectMembers finds the direct members of a group, users and nested groups
+// alike, with a single memberOf search under the base DN instead of one lookup per
+// member. It relies on the server maintaining memberOf, e.g. the OpenLDAP memberof overlay.
+func (c *Client) searchDirectMembers(groupDN string) (*groupNode, error) {
+    node := &groupNode{}
+    if c.state != nil {
+        marker, err := c.readAttribute(groupDN, c.config.Incremental.ChangeAttribute)
+        if err != nil {
+            return nil, fmt.Errorf("LDAP search for group DN '%s' failed: %w", groupDN, err)
+        }
+        node.Marker = marker
+    }
+
+    memberOf := fmt.Sprintf("(memberOf=%s)", ldap.EscapeFilter(groupDN))
+    entries, err := c.searchMembers(c.config.BaseDN, "(&"+memberOf+c.memberFilter()+")")
+    if err != nil {
+        return nil, err
+    }
+    c.reportNonUsers(c.config.BaseDN, memberOf, entries)
+    for _, entry := range entries {
+        c.addMember(node, entry)
+    }
+    return node, nil
+}
+
+// searchMembers runs a subtree search returning the attributes needed to classify members.
+func (c *Client) searchMembers(baseDN, filter string) ([]*ldap.Entry, error) {
+    searchRequest := ldap.NewSearchRequest(
+        baseDN,
+        ldap.ScopeWholeSubtree,
+        ldap.NeverDerefAliases,
+        0, 0, false,
+        filter,
+        c.userAttributes(),
+        nil,
+    )
+
+    sr, err := c.Conn.SearchWithPaging(searchRequest, c.config.PageSize)
+    if err != nil {
+        return nil, fmt.Errorf("LDAP member search '%s' under '%s' failed: %w", filter, baseDN, err)
+    }
+    log.Printf("    (Member search under '%s' returned %d entries)", baseDN, len(sr.Entries))
+    return sr.Entries, nil
+}
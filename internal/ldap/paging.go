@@ -0,0 +1,84 @@
+// internal/ldap/paging.go
+
+package ldap
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+// memberValues returns every value of the membership attribute of a group entry.
+//
+// Active Directory returns at most MaxValRange values (1500 by default) of a large
+// attribute; the entry then carries "member;range=0-1499" instead of "member", and the
+// remaining values must be fetched with further "member;range=<low>-*" reads until
+// the server answers with a range ending in "*". Reading only "member" would silently
+// truncate the group.
+func (c *Client) memberValues(entry *ldap.Entry) ([]string, error) {
+    membership := c.config.MembershipAttribute
+    values, next, ok, err := rangedValues(entry, membership)
+    if err != nil {
+        return nil, err
+    }
+    if !ok {
+        return entry.GetAttributeValues(membership), nil
+    }
+
+    for next >= 0 {
+        attribute := fmt.Sprintf("%s;range=%d-*", membership, next)
+        searchRequest := ldap.NewSearchRequest(
+            entry.DN,
+            ldap.ScopeBaseObject,
+            ldap.NeverDerefAliases,
+            0, 0, false,
+            "(objectClass=*)",
+            []string{attribute},
+            nil,
+        )
+
+        sr, err := c.Conn.Search(searchRequest)
+        if err != nil {
+            return nil, fmt.Errorf("ranged retrieval of '%s' from '%s' failed: %w", attribute, entry.DN, err)
+        }
+        if len(sr.Entries) == 0 {
+            return nil, fmt.Errorf("could not find group object for DN '%s'", entry.DN)
+        }
+
+        var page []string
+        page, next, ok, err = rangedValues(sr.Entries[0], membership)
+        if err != nil {
+            return nil, err
+        }
+        if !ok {
+            return nil, fmt.Errorf("ranged retrieval of '%s' from '%s' returned no range", attribute, entry.DN)
+        }
+        values = append(values, page...)
+    }
+    return values, nil
+}
+
+// rangedValues finds a ranged form of attribute ("<attribute>;range=<low>-<high>") on the
+// entry. It returns the values, the low bound of the next range (-1 once the last range,
+// ending in "*", has been read), and whether a ranged attribute was present at all.
+func rangedValues(entry *ldap.Entry, attribute string) ([]string, int, bool, error) {
+    prefix := strings.ToLower(attribute) + ";range="
+    for _, attr := range entry.Attributes {
+        name := strings.ToLower(attr.Name)
+        if !strings.HasPrefix(name, prefix) {
+            continue
+        }
+        _, high, found := strings.Cut(strings.TrimPrefix(name, prefix), "-")
+        if found && high == "*" {
+            return attr.Values, -1, true, nil
+        }
+        end, err := strconv.Atoi(high)
+        if !found || err != nil {
+            return nil, -1, true, fmt.Errorf("malformed ranged attribute '%s' on '%s'", attr.Name, entry.DN)
+        }
+        return attr.Values, end + 1, true, nil
+    }
+    return nil, -1, false, nil
+}
@@ -0,0 +1,127 @@
+// internal/ldap/watch.go
+
+package ldap
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "github.com/go-ldap/ldap/v3"
+)
+
+// ChangeEvent reports an entry that changed in the directory.
+type ChangeEvent struct {
+    DN string
+    // Resync is set when the server reported changes that cannot be attributed to
+    // individual DNs (e.g. deletions sent as a set of entryUUIDs). The receiver
+    // should fall back to a full sync.
+    Resync bool
+}
+
+// Watch runs a persistent RFC 4533 (syncrepl) refreshAndPersist search under baseDN on
+// a dedicated connection, separate from the one used for one-shot searches, and sends
+// every changed entry to events.
+//
+// cookie is the synchronization state returned by a previous call. With a nil cookie the
+// initial refresh, which lists every existing entry, is not reported. With a cookie the
+// refresh only contains changes made while the watch was down, and those are reported.
+//
+// Watch blocks until ctx is cancelled or the search ends, and returns the latest cookie
+// so the caller can resume from where it left off.
+func (c *Client) Watch(ctx context.Context, baseDN string, cookie []byte, events chan<- ChangeEvent) ([]byte, error) {
+    conn, err := c.dial()
+    if err != nil {
+        return cookie, err
+    }
+    defer conn.Close()
+
+    searchRequest := ldap.NewSearchRequest(
+        baseDN,
+        ldap.ScopeWholeSubtree,
+        ldap.NeverDerefAliases,
+        0, 0, false,
+        "(objectClass=*)",
+        []string{"1.1"}, // No attributes; the DN is all we need.
+        nil,
+    )
+
+    watchCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    log.Printf("Watching '%s' for changes (syncrepl refreshAndPersist)...", baseDN)
+    reportRefresh := cookie != nil
+    refreshing := true
+    resp := conn.Syncrepl(watchCtx, searchRequest, 64, ldap.SyncRequestModeRefreshAndPersist, cookie, false)
+    for resp.Next() {
+        entry := resp.Entry()
+        for _, control := range resp.Controls() {
+            switch ctrl := control.(type) {
+            case *ldap.ControlSyncState:
+                if len(ctrl.Cookie) > 0 {
+                    cookie = ctrl.Cookie
+                }
+                if entry == nil || ctrl.State == ldap.SyncStatePresent {
+                    continue
+                }
+                if refreshing && !reportRefresh {
+                    continue
+                }
+                if !send(ctx, events, ChangeEvent{DN: entry.DN}) {
+                    return cookie, ctx.Err()
+                }
+
+            case *ldap.ControlSyncInfo:
+                switch {
+                case ctrl.NewCookie != nil:
+                    cookie = ctrl.NewCookie.Cookie
+                case ctrl.RefreshDelete != nil:
+                    if len(ctrl.RefreshDelete.Cookie) > 0 {
+                        cookie = ctrl.RefreshDelete.Cookie
+                    }
+                    if ctrl.RefreshDelete.RefreshDone {
+                        refreshing = false
+                    }
+                case ctrl.RefreshPresent != nil:
+                    if len(ctrl.RefreshPresent.Cookie) > 0 {
+                        cookie = ctrl.RefreshPresent.Cookie
+                    }
+                    if ctrl.RefreshPresent.RefreshDone {
+                        refreshing = false
+                    }
+                case ctrl.SyncIdSet != nil:
+                    if len(ctrl.SyncIdSet.Cookie) > 0 {
+                        cookie = ctrl.SyncIdSet.Cookie
+                    }
+                    if !refreshing || reportRefresh {
+                        if !send(ctx, events, ChangeEvent{Resync: true}) {
+                            return cookie, ctx.Err()
+                        }
+                    }
+                }
+
+            case *ldap.ControlSyncDone:
+                if len(ctrl.Cookie) > 0 {
+                    cookie = ctrl.Cookie
+                }
+            }
+        }
+    }
+    if err := resp.Err(); err != nil {
+        return cookie, fmt.Errorf("syncrepl search on '%s' failed: %w", baseDN, err)
+    }
+    if ctx.Err() != nil {
+        return cookie, ctx.Err()
+    }
+    return cookie, fmt.Errorf("syncrepl search on '%s' ended unexpectedly", baseDN)
+}
+
+// send delivers an event unless ctx is cancelled first.
+func send(ctx context.Context, events chan<- ChangeEvent, event ChangeEvent) bool {
+    select {
+    case events <- event:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
@@ -0,0 +1,147 @@
+// internal/metrics/metrics.go
+
+package metrics
+
+import (
+    "net/http"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "pg_ldap_sync"
+
+// Change actions used as the "action" label of the changes counter.
+const (
+    ActionRoleCreated = "role_created"
+    ActionGrant       = "grant"
+    ActionRevoke      = "revoke"
+    ActionDrop        = "drop"
+    ActionDisable     = "disable"
+    ActionEnable      = "enable"
+    ActionPrivilege   = "privilege"
+    ActionAttribute   = "attribute"
+)
+
+// Sync passes used as the "pass" label. A full pass covers every mapped group with the
+// complete LDAP view; a targeted pass only the groups changed since the last one.
+const (
+    PassFull     = "full"
+    PassTargeted = "targeted"
+)
+
+// Registry holds every metric exposed by the application. A dedicated registry keeps
+// the textfile output free of Go runtime metrics that are meaningless for cron runs.
+var Registry = prometheus.NewRegistry()
+
+var (
+    changesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "changes_total",
+        Help:      "Changes applied to PostgreSQL, by database and action.",
+    }, []string{"database", "action"})
+
+    errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "errors_total",
+        Help:      "Failed database synchronizations, by database.",
+    }, []string{"database"})
+
+    databaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "database_sync_duration_seconds",
+        Help:      "Time taken to synchronize a single database, by database and pass.",
+        Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+    }, []string{"database", "pass"})
+
+    lastDatabaseSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Name:      "database_last_success_timestamp_seconds",
+        Help:      "Unix time of the last successful full synchronization, by database.",
+    }, []string{"database"})
+
+    runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "run_duration_seconds",
+        Help:      "Time taken by a sync pass over all databases, by pass.",
+        Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+    }, []string{"pass"})
+
+    lastRunSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Name:      "last_success_timestamp_seconds",
+        Help:      "Unix time of the last full sync pass in which every database succeeded.",
+    })
+
+    ldapLookupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "ldap_lookup_duration_seconds",
+        Help:      "Time taken to resolve the members of an LDAP group, including nested groups.",
+        Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+    }, []string{"result"})
+)
+
+func init() {
+    Registry.MustRegister(
+        changesTotal,
+        errorsTotal,
+        databaseDuration,
+        lastDatabaseSuccess,
+        runDuration,
+        lastRunSuccess,
+        ldapLookupDuration,
+    )
+}
+
+// AddChanges counts n applied changes of the given action for a database.
+func AddChanges(database, action string, n int) {
+    if n > 0 {
+        changesTotal.WithLabelValues(database, action).Add(float64(n))
+    }
+}
+
+// ObserveDatabase records the duration of a single database synchronization and, for a
+// full pass, whether it succeeded. Only full passes that applied their changes count as
+// a success: a targeted pass leaves most roles unchecked and a dry run changes nothing.
+// Failures are counted by AddError.
+func ObserveDatabase(database, pass string, duration time.Duration, ok bool) {
+    databaseDuration.WithLabelValues(database, pass).Observe(duration.Seconds())
+    if ok && pass == PassFull {
+        lastDatabaseSuccess.WithLabelValues(database).SetToCurrentTime()
+    }
+}
+
+// AddError counts a failed synchronization of a database, whether it failed while
+// syncing or was never started, e.g. because LDAP was unreachable.
+func AddError(database string) {
+    errorsTotal.WithLabelValues(database).Inc()
+}
+
+// ObserveRun records the duration of a sync pass and, for a full pass, whether every
+// database succeeded. ok must be false for a dry run.
+func ObserveRun(pass string, duration time.Duration, ok bool) {
+    runDuration.WithLabelValues(pass).Observe(duration.Seconds())
+    if ok && pass == PassFull {
+        lastRunSuccess.SetToCurrentTime()
+    }
+}
+
+// ObserveLDAPLookup records the latency of resolving one LDAP group.
+func ObserveLDAPLookup(duration time.Duration, err error) {
+    result := "success"
+    if err != nil {
+        result = "error"
+    }
+    ldapLookupDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+    return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile atomically writes the metrics to path, for node_exporter's textfile collector.
+func WriteTextfile(path string) error {
+    return prometheus.WriteToTextfile(path, Registry)
+}
@@ -0,0 +1,45 @@
+// internal/naming/naming.go
+
+package naming
+
+import (
+    "strings"
+    "unicode/utf8"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+)
+
+// MaxIdentifierLength is the longest identifier PostgreSQL keeps (NAMEDATALEN - 1 bytes).
+// Longer names are silently truncated by the server, so they are truncated here first
+// to make collisions visible.
+const MaxIdentifierLength = 63
+
+// RoleName derives the PostgreSQL role name of an LDAP username by applying rules
+// in order: strip domain, rewrites, lowercase, prefix and suffix, then truncation.
+func RoleName(rules config.UsernameRules, username string) string {
+    name := username
+    if rules.StripDomain {
+        if i := strings.LastIndex(name, "@"); i >= 0 {
+            name = name[:i]
+        }
+    }
+    for _, rule := range rules.Rewrite {
+        name = rule.Regexp.ReplaceAllString(name, rule.Replacement)
+    }
+    if rules.Lowercase {
+        name = strings.ToLower(name)
+    }
+    name = rules.Prefix + name + rules.Suffix
+    return truncate(name, MaxIdentifierLength)
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 character, as PostgreSQL does.
+func truncate(s string, n int) string {
+    if len(s) <= n {
+        return s
+    }
+    for n > 0 && !utf8.RuneStart(s[n]) {
+        n--
+    }
+    return s[:n]
+}
@@ -0,0 +1,123 @@
+// internal/naming/naming_test.go
+
+package naming
+
+import (
+    "regexp"
+    "strings"
+    "testing"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+)
+
+// rewrite builds a compiled rewrite rule, as config.Load does.
+func rewrite(pattern, replacement string) config.RewriteRule {
+    return config.RewriteRule{Pattern: pattern, Replacement: replacement, Regexp: regexp.MustCompile(pattern)}
+}
+
+func TestRoleName(t *testing.T) {
+    tests := []struct {
+        name     string
+        rules    config.UsernameRules
+        username string
+        want     string
+    }{
+        {
+            name:     "no rules keep the username",
+            username: "JDoe@Example.org",
+            want:     "JDoe@Example.org",
+        },
+        {
+            name:     "strip domain drops everything from the last @",
+            rules:    config.UsernameRules{StripDomain: true},
+            username: "j@doe@example.org",
+            want:     "j@doe",
+        },
+        {
+            name:     "strip domain without a domain",
+            rules:    config.UsernameRules{StripDomain: true},
+            username: "jdoe",
+            want:     "jdoe",
+        },
+        {
+            name:     "rewrite with capture groups",
+            rules:    config.UsernameRules{Rewrite: []config.RewriteRule{rewrite(`^(\w+)\.(\w+)$`, "${1}_${2}")}},
+            username: "john.doe",
+            want:     "john_doe",
+        },
+        {
+            name: "rewrites apply in order",
+            rules: config.UsernameRules{Rewrite: []config.RewriteRule{
+                rewrite(`-`, "_"),
+                rewrite(`_+`, "_"),
+            }},
+            username: "a--b-c",
+            want:     "a_b_c",
+        },
+        {
+            name: "rewrites see the name after stripping the domain and before lowercasing",
+            rules: config.UsernameRules{
+                StripDomain: true,
+                Rewrite:     []config.RewriteRule{rewrite(`^ADM-`, "admin_"), rewrite(`@`, "_at_")},
+                Lowercase:   true,
+            },
+            username: "ADM-JDoe@corp.example.org",
+            want:     "admin_jdoe",
+        },
+        {
+            name:     "prefix and suffix are added after lowercasing",
+            rules:    config.UsernameRules{Lowercase: true, Prefix: "LDAP_", Suffix: "_X"},
+            username: "JDoe",
+            want:     "LDAP_jdoe_X",
+        },
+        {
+            name:     "truncated to the identifier limit after the suffix",
+            rules:    config.UsernameRules{Suffix: "_ext"},
+            username: strings.Repeat("a", 70),
+            want:     strings.Repeat("a", 63),
+        },
+        {
+            name:     "truncation does not split a character",
+            rules:    config.UsernameRules{Prefix: strings.Repeat("p", 62)},
+            username: "äb",
+            want:     strings.Repeat("p", 62),
+        },
+        {
+            name:     "a rewrite may empty the name",
+            rules:    config.UsernameRules{Rewrite: []config.RewriteRule{rewrite(`.*`, "")}},
+            username: "jdoe",
+            want:     "",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := RoleName(tt.rules, tt.username); got != tt.want {
+                t.Errorf("RoleName(%q) = %q, want %q", tt.username, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestTruncate(t *testing.T) {
+    tests := []struct {
+        s    string
+        n    int
+        want string
+    }{
+        {s: "abc", n: 3, want: "abc"},
+        {s: "abc", n: 5, want: "abc"},
+        {s: "abcd", n: 3, want: "abc"},
+        {s: "aé", n: 2, want: "a"},  // é is 2 bytes
+        {s: "aé", n: 3, want: "aé"},
+        {s: "a€b", n: 3, want: "a"}, // € is 3 bytes
+        {s: "a€b", n: 4, want: "a€"},
+        {s: "€", n: 2, want: ""},
+        {s: "", n: 0, want: ""},
+    }
+    for _, tt := range tests {
+        if got := truncate(tt.s, tt.n); got != tt.want {
+            t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,251 @@
+// internal/plan/plan.go
+
+package plan
+
+import (
+    "sort"
+    "time"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/scope"
+)
+
+// Change is a single planned modification of a PostgreSQL role.
+// For role creation and drops, Role is the user role itself and Member is empty.
+// For grants and revokes, Role is the group role and Member is the user role.
+type Change struct {
+    Role      string `json:"role"`
+    Member    string `json:"member,omitempty"`
+    LDAPGroup string `json:"ldap_group,omitempty"` // CN of the mapped LDAP group behind the change
+    LDAPDN    string `json:"ldap_dn,omitempty"`    // DN of the LDAP user behind the change
+    LDAPUser  string `json:"ldap_user,omitempty"`  // LDAP username the role name was derived from
+    // Objects lists the objects and privileges of a dropped role across the cluster,
+    // with what the configured cleanup does to each. It is filled in while planning and
+    // refreshed when the drop is executed.
+    Objects []OwnedObject `json:"objects,omitempty"`
+}
+
+// OwnedObject is an object owned by, or granting privileges to, a role being dropped.
+type OwnedObject struct {
+    Database   string `json:"database,omitempty"` // empty for shared objects such as databases
+    Object     string `json:"object"`     // as described by pg_describe_object
+    Dependency string `json:"dependency"` // owner, privilege or policy
+    Action     string `json:"action"`     // e.g. "reassigned to app_owner", "dropped", "revoked", "kept"
+}
+
+// AttributeChange changes one attribute or parameter of a user role.
+type AttributeChange struct {
+    Role      string `json:"role"`
+    Attribute string `json:"attribute"` // e.g. "CONNECTION LIMIT", "VALID UNTIL", "CREATEDB", "statement_timeout"
+    From      string `json:"from"`
+    To        string `json:"to"`
+    SQL       string `json:"sql"`
+}
+
+// PrivilegeChange grants or revokes privileges of a mapped role on a single object.
+type PrivilegeChange struct {
+    Role       string   `json:"role"`
+    LDAPGroup  string   `json:"ldap_group,omitempty"`
+    Action     string   `json:"action"` // grant or revoke
+    Privileges []string `json:"privileges"`
+    Object     string   `json:"object"` // e.g. "DATABASE myapp_db", "SCHEMA app", "TABLE app.orders"
+    SQL        string   `json:"sql"`
+}
+
+// UnresolvedGroup is a mapped LDAP group whose members could not be resolved.
+type UnresolvedGroup struct {
+    LDAPGroup string `json:"ldap_group"`
+    Role      string `json:"role"`
+    Error     string `json:"error"`
+    // PrivilegesSkipped is set when the role declares privileges, which are then not
+    // reconciled either.
+    PrivilegesSkipped bool `json:"privileges_skipped,omitempty"`
+}
+
+// Source identifies the LDAP entry that justifies a role or membership.
+type Source struct {
+    LDAPGroup  string
+    DN         string
+    Username   string     // LDAP username, before the username rules were applied
+    ValidUntil *time.Time // Account expiry from LDAP, nil if it never expires
+}
+
+// Desired is the target state of a database as resolved from LDAP.
+type Desired struct {
+    DefaultGroup string
+    // Scope selects the role names managed by the sync.
+    Scope *scope.Matcher
+    // Users holds every valid LDAP user for the database, keyed by role name.
+    Users map[string]Source
+    // Roles holds the desired state of each mapped group role, keyed by role name.
+    Roles map[string]RoleTarget
+    // Ownership selects the marker that identifies roles created by the sync.
+    Ownership config.OwnershipConfig
+    // Deprovision selects what happens to the roles of users no longer in LDAP.
+    Deprovision config.DeprovisionConfig
+    // Attributes holds the merged attribute policy of each user, keyed by role name.
+    // Nil if no policy is configured, in which case attributes are left alone.
+    Attributes map[string]config.RoleAttributes
+    // ManagedParameters lists the parameter names set by any attribute policy. Only these
+    // are RESET on a user whose policy does not set them; others are left to the DBA.
+    ManagedParameters []string
+    // ManageValidUntil copies Source.ValidUntil to VALID UNTIL.
+    ManageValidUntil bool
+    // GroupRoles lists every group role the database needs: the default group and all
+    // mapped roles, resolved or not.
+    GroupRoles []string
+    // CreateMissingRoles creates missing group roles with GroupRoleAttributes instead of
+    // failing the plan.
+    CreateMissingRoles  bool
+    GroupRoleAttributes config.GroupRoleAttributes
+    // SkipDeprovision is set when Users is not the complete LDAP view of the database,
+    // e.g. in a targeted sync of a few roles, so no user may be dropped.
+    SkipDeprovision bool
+}
+
+// RoleTarget is the desired membership of a single mapped group role.
+type RoleTarget struct {
+    LDAPGroup string
+    Members   map[string]Source
+    // Privileges of the role itself, or nil if they are not managed.
+    Privileges *config.Privileges
+}
+
+// DatabasePlan lists every change the sync will make to a single database.
+type DatabasePlan struct {
+    Alias        string   `json:"alias"`
+    DefaultGroup string   `json:"default_group"`
+    // GroupRolesCreated lists the missing group roles created with create_missing_roles.
+    GroupRolesCreated []Change `json:"group_roles_created"`
+    RolesCreated []Change `json:"roles_created"`
+    Grants       []Change `json:"grants"`
+    Revokes      []Change `json:"revokes"`
+    Drops        []Change `json:"drops"`
+    Disables     []Change `json:"disables"` // ALTER ROLE ... NOLOGIN of users no longer in LDAP
+    Enables      []Change `json:"enables"`  // ALTER ROLE ... LOGIN of disabled users back in LDAP
+    // Attributes lists the ALTER ROLE statements reconciling user role attributes.
+    Attributes []AttributeChange `json:"attributes"`
+    // Privileges lists the grants and revokes of the mapped roles on database objects.
+    Privileges []PrivilegeChange `json:"privileges"`
+    // UnresolvedGroups lists the mapped LDAP groups that could not be read. Their roles
+    // are left unchanged and nothing is deprovisioned in the database.
+    UnresolvedGroups []UnresolvedGroup `json:"unresolved_groups,omitempty"`
+    // GroupRoleAttributes are the attributes of created group roles.
+    GroupRoleAttributes config.GroupRoleAttributes `json:"-"`
+    // Ownership is the marker applied to created roles.
+    Ownership config.OwnershipConfig `json:"-"`
+    // Deprovision controls how planned drops are executed.
+    Deprovision config.DeprovisionConfig `json:"-"`
+    // CurrentMembers holds the number of managed members of each role when the plan was
+    // computed, the baseline of the safety limits.
+    CurrentMembers map[string]int `json:"-"`
+}
+
+// Plan is the full change set of one sync run across all databases.
+type Plan struct {
+    GeneratedAt time.Time       `json:"generated_at"`
+    DryRun      bool            `json:"dry_run"`
+    Databases   []*DatabasePlan `json:"databases"`
+}
+
+// New returns an empty plan for a run.
+func New(dryRun bool) *Plan {
+    return &Plan{
+        GeneratedAt: time.Now().UTC(),
+        DryRun:      dryRun,
+        Databases:   []*DatabasePlan{},
+    }
+}
+
+// NewDatabasePlan returns an empty plan for a single database.
+func NewDatabasePlan(alias, defaultGroup string) *DatabasePlan {
+    return &DatabasePlan{
+        Alias:             alias,
+        DefaultGroup:      defaultGroup,
+        GroupRolesCreated: []Change{},
+        RolesCreated:      []Change{},
+        Grants:            []Change{},
+        Revokes:           []Change{},
+        Drops:             []Change{},
+        Disables:          []Change{},
+        Enables:           []Change{},
+        Attributes:        []AttributeChange{},
+        Privileges:        []PrivilegeChange{},
+        CurrentMembers:    make(map[string]int),
+    }
+}
+
+// Len returns the number of changes in the database plan.
+func (p *DatabasePlan) Len() int {
+    return len(p.GroupRolesCreated) + len(p.RolesCreated) + len(p.Grants) + len(p.Revokes) + len(p.Drops) + len(p.Disables) + len(p.Enables) + len(p.Attributes) + len(p.Privileges)
+}
+
+// Len returns the number of changes across all databases.
+func (p *Plan) Len() int {
+    n := 0
+    for _, db := range p.Databases {
+        n += db.Len()
+    }
+    return n
+}
+
+// GrantedRoles returns the group roles that have membership changes, in sorted order.
+// The default group is excluded, as its grants are applied together with role creation.
+func (p *DatabasePlan) GrantedRoles() []string {
+    seen := make(map[string]bool)
+    for _, c := range p.Grants {
+        if c.Role != p.DefaultGroup {
+            seen[c.Role] = true
+        }
+    }
+    for _, c := range p.Revokes {
+        seen[c.Role] = true
+    }
+    roles := make([]string, 0, len(seen))
+    for role := range seen {
+        roles = append(roles, role)
+    }
+    sort.Strings(roles)
+    return roles
+}
+
+// MembershipChanges returns the grants and revokes planned for a single group role.
+// Grants of the default group are excluded, as they are applied together with role creation.
+func (p *DatabasePlan) MembershipChanges(role string) ([]Change, []Change) {
+    var grants, revokes []Change
+    for _, c := range p.Grants {
+        if c.Role == role && role != p.DefaultGroup {
+            grants = append(grants, c)
+        }
+    }
+    for _, c := range p.Revokes {
+        if c.Role == role {
+            revokes = append(revokes, c)
+        }
+    }
+    return grants, revokes
+}
+
+// Sort orders every change list so plans are stable across runs.
+func (p *DatabasePlan) Sort() {
+    for _, changes := range [][]Change{p.GroupRolesCreated, p.RolesCreated, p.Grants, p.Revokes, p.Drops, p.Disables, p.Enables} {
+        sort.Slice(changes, func(i, j int) bool {
+            if changes[i].Role != changes[j].Role {
+                return changes[i].Role < changes[j].Role
+            }
+            return changes[i].Member < changes[j].Member
+        })
+    }
+    sort.SliceStable(p.Attributes, func(i, j int) bool {
+        if p.Attributes[i].Role != p.Attributes[j].Role {
+            return p.Attributes[i].Role < p.Attributes[j].Role
+        }
+        return p.Attributes[i].Attribute < p.Attributes[j].Attribute
+    })
+    sort.SliceStable(p.Privileges, func(i, j int) bool {
+        if p.Privileges[i].Role != p.Privileges[j].Role {
+            return p.Privileges[i].Role < p.Privileges[j].Role
+        }
+        return p.Privileges[i].Object < p.Privileges[j].Object
+    })
+}
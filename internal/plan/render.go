@@ -0,0 +1,102 @@
+// internal/plan/render.go
+
+package plan
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+)
+
+// RenderText writes a human-readable summary of the plan.
+func RenderText(w io.Writer, p *Plan) error {
+    for _, db := range p.Databases {
+        for _, g := range db.UnresolvedGroups {
+            skipped := "deprovisioning skipped"
+            if g.PrivilegesSkipped {
+                skipped = "privileges and deprovisioning skipped"
+            }
+            if _, err := fmt.Fprintf(w, "[%s] UNRESOLVED LDAP group %s: role %s left unchanged, %s (%s)\n", db.Alias, g.LDAPGroup, g.Role, skipped, g.Error); err != nil {
+                return err
+            }
+        }
+        if db.Len() == 0 {
+            if _, err := fmt.Fprintf(w, "[%s] no changes.\n", db.Alias); err != nil {
+                return err
+            }
+            continue
+        }
+        for _, c := range db.GroupRolesCreated {
+            if _, err := fmt.Fprintf(w, "[%s] CREATE ROLE %s WITH %s%s\n", db.Alias, c.Role, db.GroupRoleAttributes.Options(), describeSource(c)); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.RolesCreated {
+            if _, err := fmt.Fprintf(w, "[%s] CREATE ROLE %s WITH LOGIN%s\n", db.Alias, c.Role, describeSource(c)); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.Enables {
+            if _, err := fmt.Fprintf(w, "[%s] ALTER ROLE %s LOGIN%s\n", db.Alias, c.Role, describeSource(c)); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.Attributes {
+            if _, err := fmt.Fprintf(w, "[%s] %s\n", db.Alias, c.SQL); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.Grants {
+            if _, err := fmt.Fprintf(w, "[%s] GRANT %s TO %s%s\n", db.Alias, c.Role, c.Member, describeSource(c)); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.Privileges {
+            if _, err := fmt.Fprintf(w, "[%s] %s\n", db.Alias, c.SQL); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.Revokes {
+            if _, err := fmt.Fprintf(w, "[%s] REVOKE %s FROM %s%s\n", db.Alias, c.Role, c.Member, describeSource(c)); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.Disables {
+            if _, err := fmt.Fprintf(w, "[%s] ALTER ROLE %s NOLOGIN\n", db.Alias, c.Role); err != nil {
+                return err
+            }
+        }
+        for _, c := range db.Drops {
+            if _, err := fmt.Fprintf(w, "[%s] DROP ROLE %s\n", db.Alias, c.Role); err != nil {
+                return err
+            }
+            for _, o := range c.Objects {
+                where := o.Database
+                if where == "" {
+                    where = "shared"
+                }
+                if _, err := fmt.Fprintf(w, "[%s]     %s: %s (%s), %s\n", db.Alias, where, o.Object, o.Dependency, o.Action); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// RenderJSON writes the plan as an indented JSON document.
+func RenderJSON(w io.Writer, p *Plan) error {
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(p)
+}
+
+func describeSource(c Change) string {
+    switch {
+    case c.LDAPGroup != "" && c.LDAPDN != "":
+        return fmt.Sprintf("  (ldap group %s, %s)", c.LDAPGroup, c.LDAPDN)
+    case c.LDAPGroup != "":
+        return fmt.Sprintf("  (ldap group %s)", c.LDAPGroup)
+    }
+    return ""
+}
@@ -0,0 +1,68 @@
+// internal/plan/safety.go
+
+package plan
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+)
+
+// ErrLimitExceeded is returned for a database whose plan removes more access than the
+// configured safety limits allow.
+var ErrLimitExceeded = errors.New("safety limit exceeded")
+
+// CheckLimits compares the revokes and deprovisioned users of the plan with the safety
+// limits and returns an error wrapping ErrLimitExceeded that lists every violation.
+func (p *DatabasePlan) CheckLimits(safety config.SafetyConfig) error {
+    var violations []string
+
+    revokes := make(map[string]int)
+    for _, c := range p.Revokes {
+        revokes[c.Role]++
+    }
+    roles := make([]string, 0, len(revokes))
+    for role := range revokes {
+        roles = append(roles, role)
+    }
+    sort.Strings(roles)
+
+    totalRevokes, totalMembers := 0, 0
+    for _, role := range roles {
+        if v := checkLimit(safety.PerRole, revokes[role], p.CurrentMembers[role]); v != "" {
+            violations = append(violations, fmt.Sprintf("role '%s': %s", role, v))
+        }
+        totalRevokes += revokes[role]
+    }
+    for role, n := range p.CurrentMembers {
+        if role != p.DefaultGroup {
+            totalMembers += n
+        }
+    }
+    if v := checkLimit(safety.PerDatabase, totalRevokes, totalMembers); v != "" {
+        violations = append(violations, "revokes: "+v)
+    }
+    if v := checkLimit(safety.PerDatabase, len(p.Drops)+len(p.Disables), p.CurrentMembers[p.DefaultGroup]); v != "" {
+        violations = append(violations, "deprovisioned users: "+v)
+    }
+
+    if len(violations) == 0 {
+        return nil
+    }
+    return fmt.Errorf("%w: %s", ErrLimitExceeded, strings.Join(violations, "; "))
+}
+
+// checkLimit returns how removals out of current members exceed the limit, or "" if
+// they do not.
+func checkLimit(l config.ChangeLimit, removals, current int) string {
+    if l.Max > 0 && removals > l.Max {
+        return fmt.Sprintf("%d removals exceed the maximum of %d", removals, l.Max)
+    }
+    if l.Percent > 0 && current > 0 && float64(removals)*100 > l.Percent*float64(current) {
+        return fmt.Sprintf("%d of %d members (%.1f%%) exceed the maximum of %g%%", removals, current, float64(removals)*100/float64(current), l.Percent)
+    }
+    return ""
+}
@@ -0,0 +1,200 @@
+// internal/plan/safety_test.go
+
+package plan
+
+import (
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+)
+
+// changes returns n changes on role, for building plans.
+func changes(role string, n int) []Change {
+    c := make([]Change, n)
+    for i := range c {
+        c[i] = Change{Role: role}
+    }
+    return c
+}
+
+func TestCheckLimits(t *testing.T) {
+    tests := []struct {
+        name    string
+        plan    DatabasePlan
+        safety  config.SafetyConfig
+        violate []string // substrings of the violations, none if the plan is within limits
+    }{
+        {
+            name: "zero limits are disabled",
+            plan: DatabasePlan{
+                DefaultGroup:   "ldap_users",
+                Revokes:        changes("readers", 10),
+                Drops:          changes("", 10),
+                CurrentMembers: map[string]int{"ldap_users": 10, "readers": 10},
+            },
+        },
+        {
+            name: "per-role max at the limit",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 3),
+                CurrentMembers: map[string]int{"readers": 100},
+            },
+            safety: config.SafetyConfig{PerRole: config.ChangeLimit{Max: 3}},
+        },
+        {
+            name: "per-role max exceeded",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 4),
+                CurrentMembers: map[string]int{"readers": 100},
+            },
+            safety:  config.SafetyConfig{PerRole: config.ChangeLimit{Max: 3}},
+            violate: []string{"role 'readers': 4 removals exceed the maximum of 3"},
+        },
+        {
+            name: "per-role percent at the limit",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 2),
+                CurrentMembers: map[string]int{"readers": 5},
+            },
+            safety: config.SafetyConfig{PerRole: config.ChangeLimit{Percent: 40}},
+        },
+        {
+            name: "per-role percent just over the limit",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 2),
+                CurrentMembers: map[string]int{"readers": 5},
+            },
+            safety:  config.SafetyConfig{PerRole: config.ChangeLimit{Percent: 39.9}},
+            violate: []string{"role 'readers': 2 of 5 members (40.0%) exceed the maximum of 39.9%"},
+        },
+        {
+            name: "percent is not rounded down",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 1),
+                CurrentMembers: map[string]int{"readers": 3},
+            },
+            safety:  config.SafetyConfig{PerRole: config.ChangeLimit{Percent: 33.3}},
+            violate: []string{"1 of 3 members (33.3%) exceed the maximum of 33.3%"},
+        },
+        {
+            name: "percent just above the share",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 1),
+                CurrentMembers: map[string]int{"readers": 3},
+            },
+            safety: config.SafetyConfig{PerRole: config.ChangeLimit{Percent: 33.4}},
+        },
+        {
+            name: "percent is not checked without current members",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 1),
+                CurrentMembers: map[string]int{},
+            },
+            safety: config.SafetyConfig{PerRole: config.ChangeLimit{Percent: 10}},
+        },
+        {
+            name: "max and percent are both checked",
+            plan: DatabasePlan{
+                Revokes:        changes("readers", 2),
+                CurrentMembers: map[string]int{"readers": 100},
+            },
+            safety:  config.SafetyConfig{PerRole: config.ChangeLimit{Max: 1, Percent: 50}},
+            violate: []string{"2 removals exceed the maximum of 1"},
+        },
+        {
+            name: "revokes are counted per role",
+            plan: DatabasePlan{
+                Revokes:        append(changes("readers", 2), changes("writers", 2)...),
+                CurrentMembers: map[string]int{"readers": 10, "writers": 10},
+            },
+            safety: config.SafetyConfig{PerRole: config.ChangeLimit{Max: 2}},
+        },
+        {
+            name: "revokes are summed per database",
+            plan: DatabasePlan{
+                Revokes:        append(changes("readers", 2), changes("writers", 2)...),
+                CurrentMembers: map[string]int{"readers": 10, "writers": 10},
+            },
+            safety:  config.SafetyConfig{PerRole: config.ChangeLimit{Max: 2}, PerDatabase: config.ChangeLimit{Max: 3}},
+            violate: []string{"revokes: 4 removals exceed the maximum of 3"},
+        },
+        {
+            name: "database revoke share leaves out the default group",
+            plan: DatabasePlan{
+                DefaultGroup:   "ldap_users",
+                Revokes:        changes("readers", 2),
+                CurrentMembers: map[string]int{"ldap_users": 100, "readers": 4},
+            },
+            safety:  config.SafetyConfig{PerDatabase: config.ChangeLimit{Percent: 40}},
+            violate: []string{"revokes: 2 of 4 members (50.0%)"},
+        },
+        {
+            name: "deprovisioned users at the limit of the default group",
+            plan: DatabasePlan{
+                DefaultGroup:   "ldap_users",
+                Drops:          changes("", 2),
+                Disables:       changes("", 1),
+                CurrentMembers: map[string]int{"ldap_users": 10, "readers": 3},
+            },
+            safety: config.SafetyConfig{PerDatabase: config.ChangeLimit{Percent: 30}},
+        },
+        {
+            name: "deprovisioned users are measured against the default group",
+            plan: DatabasePlan{
+                DefaultGroup:   "ldap_users",
+                Drops:          changes("", 2),
+                Disables:       changes("", 1),
+                CurrentMembers: map[string]int{"ldap_users": 10, "readers": 100},
+            },
+            safety:  config.SafetyConfig{PerDatabase: config.ChangeLimit{Percent: 29}},
+            violate: []string{"deprovisioned users: 3 of 10 members (30.0%) exceed the maximum of 29%"},
+        },
+        {
+            name: "per-role limits do not apply to deprovisioning",
+            plan: DatabasePlan{
+                DefaultGroup:   "ldap_users",
+                Drops:          changes("", 5),
+                CurrentMembers: map[string]int{"ldap_users": 5},
+            },
+            safety: config.SafetyConfig{PerRole: config.ChangeLimit{Max: 1, Percent: 10}},
+        },
+        {
+            name: "every violation is listed",
+            plan: DatabasePlan{
+                DefaultGroup:   "ldap_users",
+                Revokes:        append(changes("writers", 3), changes("readers", 3)...),
+                Drops:          changes("", 3),
+                CurrentMembers: map[string]int{"ldap_users": 3, "readers": 3, "writers": 3},
+            },
+            safety: config.SafetyConfig{PerRole: config.ChangeLimit{Max: 2}, PerDatabase: config.ChangeLimit{Max: 2}},
+            violate: []string{
+                "role 'readers': 3 removals",
+                "role 'writers': 3 removals",
+                "revokes: 6 removals",
+                "deprovisioned users: 3 removals",
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := tt.plan.CheckLimits(tt.safety)
+            if len(tt.violate) == 0 {
+                if err != nil {
+                    t.Fatalf("CheckLimits = %v, want nil", err)
+                }
+                return
+            }
+            if !errors.Is(err, ErrLimitExceeded) {
+                t.Fatalf("CheckLimits = %v, want an error wrapping ErrLimitExceeded", err)
+            }
+            for _, want := range tt.violate {
+                if !strings.Contains(err.Error(), want) {
+                    t.Errorf("CheckLimits = %q, want it to contain %q", err, want)
+                }
+            }
+        })
+    }
+}
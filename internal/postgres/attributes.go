@@ -0,0 +1,199 @@
+// internal/postgres/attributes.go
+
+package postgres
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "maps"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
+    "github.com/jackc/pgx/v5"
+)
+
+// roleAttributes is the current state of the attributes the sync reconciles on a user role.
+type roleAttributes struct {
+    connectionLimit int
+    flags           map[string]bool // keyed by config.RoleAttributeNames
+    privileged      bool            // SUPERUSER or REPLICATION
+    validUntil      *time.Time
+    parameters      map[string]string
+}
+
+// newRoleAttributes returns the attributes of a role created WITH LOGIN and nothing else.
+func newRoleAttributes() roleAttributes {
+    return roleAttributes{connectionLimit: -1, flags: make(map[string]bool), parameters: make(map[string]string)}
+}
+
+// readRoleAttributes returns the current attributes of the given roles that exist.
+// Only role-wide parameters (ALTER ROLE ... SET, not IN DATABASE) are read.
+func readRoleAttributes(ctx context.Context, q querier, roles []string) (map[string]roleAttributes, error) {
+    rows, err := q.Query(ctx, `
+        SELECT r.rolname, r.rolconnlimit, r.rolcreatedb, r.rolcreaterole, r.rolsuper OR r.rolreplication,
+               CASE WHEN r.rolvaliduntil IS NULL OR r.rolvaliduntil = 'infinity' THEN NULL ELSE r.rolvaliduntil END,
+               COALESCE(s.setconfig, '{}')
+        FROM pg_catalog.pg_roles r
+        LEFT JOIN pg_catalog.pg_db_role_setting s ON s.setrole = r.oid AND s.setdatabase = 0
+        WHERE r.rolname = ANY($1)`, roles)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read role attributes: %w", err)
+    }
+
+    current := make(map[string]roleAttributes, len(roles))
+    var name string
+    var connectionLimit int
+    var createDB, createRole, privileged bool
+    var validUntil *time.Time
+    var settings []string
+    _, err = pgx.ForEachRow(rows, []any{&name, &connectionLimit, &createDB, &createRole, &privileged, &validUntil, &settings}, func() error {
+        found := newRoleAttributes()
+        found.connectionLimit, found.privileged, found.validUntil = connectionLimit, privileged, validUntil
+        found.flags["CREATEDB"], found.flags["CREATEROLE"] = createDB, createRole
+        for _, setting := range settings {
+            if key, value, ok := strings.Cut(setting, "="); ok {
+                found.parameters[key] = value
+            }
+        }
+        current[name] = found
+        return nil
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to read role attributes: %w", err)
+    }
+    return current, nil
+}
+
+// planAttributes adds the ALTER ROLE statements that bring the attributes of the given
+// user roles in line with their policies and LDAP account expiry. Roles in created do
+// not exist yet and are compared with the attributes of a new role.
+func planAttributes(ctx context.Context, q querier, p *plan.DatabasePlan, desired plan.Desired, existing, created []string) error {
+    if desired.Attributes == nil && !desired.ManageValidUntil {
+        return nil
+    }
+    current, err := readRoleAttributes(ctx, q, existing)
+    if err != nil {
+        return err
+    }
+    for _, user := range created {
+        current[user] = newRoleAttributes()
+    }
+
+    for _, user := range slices.Sorted(maps.Keys(current)) {
+        have := current[user]
+        if have.privileged {
+            log.Printf("WARNING [%s]: Role '%s' has SUPERUSER or REPLICATION, which the sync never grants; its attributes are left unchanged.", p.Alias, user)
+            continue
+        }
+        role := pgxQuoteIdentifier(user)
+        add := func(attribute, from, to, sql string) {
+            p.Attributes = append(p.Attributes, plan.AttributeChange{Role: user, Attribute: attribute, From: from, To: to, SQL: "ALTER ROLE " + role + " " + sql})
+        }
+
+        if desired.Attributes != nil {
+            policy := desired.Attributes[user]
+            limit := -1
+            if policy.ConnectionLimit != nil {
+                limit = *policy.ConnectionLimit
+            }
+            if have.connectionLimit != limit {
+                add("CONNECTION LIMIT", strconv.Itoa(have.connectionLimit), strconv.Itoa(limit), fmt.Sprintf("CONNECTION LIMIT %d", limit))
+            }
+
+            for _, attribute := range config.RoleAttributeNames {
+                want := slices.Contains(policy.Attributes, attribute)
+                if have.flags[attribute] == want {
+                    continue
+                }
+                option := attribute
+                if !want {
+                    option = "NO" + attribute
+                }
+                add(attribute, strconv.FormatBool(have.flags[attribute]), strconv.FormatBool(want), option)
+            }
+
+            for _, name := range slices.Sorted(maps.Keys(policy.Parameters)) {
+                value, set := have.parameters[name]
+                want := policy.Parameters[name]
+                if set && settingEqual(name, value, want) {
+                    continue
+                }
+                add(name, value, want, "SET "+pgxQuoteIdentifier(name)+" = "+settingSQL(name, want))
+            }
+            // Parameters no policy sets were set by someone else and are left alone.
+            for _, name := range desired.ManagedParameters {
+                if _, wanted := policy.Parameters[name]; wanted {
+                    continue
+                }
+                if value, set := have.parameters[name]; set {
+                    add(name, value, "", "RESET "+pgxQuoteIdentifier(name))
+                }
+            }
+        }
+
+        if desired.ManageValidUntil {
+            want := desired.Users[user].ValidUntil
+            switch {
+            case want == nil && have.validUntil == nil:
+            case want != nil && have.validUntil != nil && want.Truncate(time.Second).Equal(have.validUntil.Truncate(time.Second)):
+            default:
+                to := "infinity"
+                if want != nil {
+                    to = want.UTC().Format(time.RFC3339)
+                }
+                from := "infinity"
+                if have.validUntil != nil {
+                    from = have.validUntil.UTC().Format(time.RFC3339)
+                }
+                add("VALID UNTIL", from, to, "VALID UNTIL "+quoteLiteral(to))
+            }
+        }
+    }
+    return nil
+}
+
+// listParameters are the parameters that take a comma-separated list (GUC_LIST_INPUT)
+// and can be set on a role. Their items are quoted one by one; any other value is a
+// single literal, commas included.
+var listParameters = []string{"datestyle", "local_preload_libraries", "search_path", "session_preload_libraries", "temp_tablespaces"}
+
+// settingSQL returns the value of a SET clause for a parameter.
+func settingSQL(name, value string) string {
+    if !slices.Contains(listParameters, name) {
+        return quoteLiteral(value)
+    }
+    items := settingItems(value)
+    quoted := make([]string, len(items))
+    for i, item := range items {
+        quoted[i] = quoteLiteral(item)
+    }
+    return strings.Join(quoted, ", ")
+}
+
+// settingEqual reports whether the value of a role setting, as stored in
+// pg_db_role_setting, is the configured value. List parameters compare item by item, so
+// they compare equal however they were quoted and spaced.
+func settingEqual(name, have, want string) bool {
+    if !slices.Contains(listParameters, name) {
+        return have == want
+    }
+    return slices.Equal(settingItems(have), settingItems(want))
+}
+
+// settingItems splits the value of a list parameter into its items.
+func settingItems(value string) []string {
+    items := strings.Split(value, ",")
+    for i, item := range items {
+        item = strings.TrimSpace(item)
+        if len(item) >= 2 && item[0] == '"' && item[len(item)-1] == '"' {
+            item = strings.ReplaceAll(item[1:len(item)-1], `""`, `"`)
+        }
+        items[i] = item
+    }
+    return items
+}
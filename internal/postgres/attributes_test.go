@@ -0,0 +1,88 @@
+// internal/postgres/attributes_test.go
+
+package postgres
+
+import (
+    "slices"
+    "testing"
+)
+
+func TestSettingSQL(t *testing.T) {
+    tests := []struct {
+        name, value, want string
+    }{
+        {name: "statement_timeout", value: "5min", want: `'5min'`},
+        {name: "application_name", value: "a, b", want: `'a, b'`},
+        {name: "application_name", value: `it's`, want: `'it''s'`},
+        {name: "search_path", value: `"$user", public`, want: `'$user', 'public'`},
+        {name: "search_path", value: `app,"My Schema",public`, want: `'app', 'My Schema', 'public'`},
+        {name: "search_path", value: `"a""b"`, want: `'a"b'`},
+        {name: "datestyle", value: "ISO, DMY", want: `'ISO', 'DMY'`},
+        {name: "temp_tablespaces", value: `fast\disk`, want: `E'fast\\disk'`},
+    }
+    for _, tt := range tests {
+        if got := settingSQL(tt.name, tt.value); got != tt.want {
+            t.Errorf("settingSQL(%q, %q) = %s, want %s", tt.name, tt.value, got, tt.want)
+        }
+    }
+}
+
+func TestSettingItems(t *testing.T) {
+    tests := map[string][]string{
+        `public`:                 {"public"},
+        `"$user", public`:        {"$user", "public"},
+        `"$user",public`:         {"$user", "public"},
+        `  app ,  "My Schema"  `: {"app", "My Schema"},
+        `"a""b", c`:              {`a"b`, "c"},
+        `""`:                     {""},
+    }
+    for value, want := range tests {
+        if got := settingItems(value); !slices.Equal(got, want) {
+            t.Errorf("settingItems(%q) = %q, want %q", value, got, want)
+        }
+    }
+}
+
+func TestSettingEqual(t *testing.T) {
+    tests := []struct {
+        name, have, want string
+        equal            bool
+    }{
+        // PostgreSQL stores list parameters with ", " and quotes items that need it.
+        {name: "search_path", have: `"$user", public`, want: `$user,public`, equal: true},
+        {name: "search_path", have: `"$user", public`, want: `"$user", public`, equal: true},
+        {name: "search_path", have: `app, "My Schema"`, want: `app,"My Schema"`, equal: true},
+        {name: "search_path", have: `app, public`, want: `public, app`, equal: false},
+        {name: "search_path", have: `app`, want: `app, public`, equal: false},
+        {name: "statement_timeout", have: "5min", want: "5min", equal: true},
+        {name: "statement_timeout", have: "5min", want: "300s", equal: false},
+        {name: "application_name", have: "a, b", want: "a,b", equal: false},
+    }
+    for _, tt := range tests {
+        if got := settingEqual(tt.name, tt.have, tt.want); got != tt.equal {
+            t.Errorf("settingEqual(%q, %q, %q) = %v, want %v", tt.name, tt.have, tt.want, got, tt.equal)
+        }
+    }
+}
+
+// TestSettingRoundTrip checks that a value set with settingSQL, as PostgreSQL stores
+// it, compares equal to the configured value, so it is not set again on every run.
+func TestSettingRoundTrip(t *testing.T) {
+    tests := []struct {
+        configured string
+        stored     string // setconfig value after SET search_path = settingSQL(configured)
+    }{
+        {configured: `"$user", public`, stored: `"$user", public`},
+        {configured: `$user,public`, stored: `"$user", public`},
+        {configured: `app, "My Schema"`, stored: `app, "My Schema"`},
+    }
+    for _, tt := range tests {
+        sql := settingSQL("search_path", tt.configured)
+        if !settingEqual("search_path", tt.stored, tt.configured) {
+            t.Errorf("search_path %q set as %s and stored as %q does not compare equal", tt.configured, sql, tt.stored)
+        }
+        if again := settingSQL("search_path", tt.stored); again != sql {
+            t.Errorf("search_path stored as %q is set as %s, want %s", tt.stored, again, sql)
+        }
+    }
+}
@@ -2,11 +2,16 @@ package postgres
 
 import (
     "context"
+    "errors"
     "fmt"
     "log"
+    "maps"
+    "slices"
     "strings"
+    "time"
 
     "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
     "github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5"
 )
@@ -26,10 +31,7 @@ func NewClient(cfg config.PostgresConn) *Client {
 
 // Connect establishes a connection pool to the PostgreSQL server.
 func (c *Client) Connect(ctx context.Context) error {
-    connString := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-        c.config.User, c.config.Password, c.config.Host, c.config.Port, c.config.DBName, c.config.SSLMode)
-
-    pool, err := pgxpool.New(ctx, connString)
+    pool, err := pgxpool.New(ctx, c.connString(c.config.DBName))
     if err != nil {
         return fmt.Errorf("unable to create connection pool: %w", err)
     }
@@ -44,6 +46,12 @@ func (c *Client) Connect(ctx context.Context) error {
     return nil
 }
 
+// connString returns the connection string for a database on the client's server.
+func (c *Client) connString(dbName string) string {
+    return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
+        c.config.User, c.config.Password, c.config.Host, c.config.Port, dbName, c.config.SSLMode)
+}
+
 // Close gracefully terminates the connection pool.
 func (c *Client) Close() {
     if c.Pool != nil {
@@ -52,102 +60,211 @@ func (c *Client) Close() {
     }
 }
 
-// EnsureUsersExist creates any missing user roles in a single transaction.
-// This is Phase 1 of the synchronization process.
-func (c *Client) EnsureUsersExist(ctx context.Context, users []string, defaultGroup string) error {
-    tx, err := c.Pool.Begin(ctx)
+// Plan computes every change needed to bring the database in line with the desired
+// LDAP state, without changing anything. The returned plan is what the executor applies.
+func (c *Client) Plan(ctx context.Context, alias string, desired plan.Desired) (*plan.DatabasePlan, error) {
+    p := plan.NewDatabasePlan(alias, desired.DefaultGroup)
+    p.Ownership = desired.Ownership
+    p.Deprovision = desired.Deprovision
+    filter := roleFilter{scope: desired.Scope, ownership: desired.Ownership}
+    p.GroupRoleAttributes = desired.GroupRoleAttributes
+
+    // Pre-flight: every grant below targets a group role, so a missing one either gets
+    // created first or stops the database before anything is changed.
+    missingGroups, err := missingRoles(ctx, c.Pool, desired.GroupRoles)
     if err != nil {
-        return fmt.Errorf("failed to begin user creation transaction: %w", err)
+        return nil, err
     }
-    defer tx.Rollback(ctx)
-
-    for _, user := range users {
-        var exists bool
-        err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1)", user).Scan(&exists)
-        if err != nil {
-            return fmt.Errorf("failed to check for existence of role '%s': %w", user, err)
+    if len(missingGroups) > 0 && !desired.CreateMissingRoles {
+        return nil, fmt.Errorf("group roles %v do not exist; create them or enable sync_policy.create_missing_roles", missingGroups)
+    }
+    for _, role := range missingGroups {
+        change := plan.Change{Role: role}
+        if target, ok := desired.Roles[role]; ok {
+            change.LDAPGroup = target.LDAPGroup
         }
+        p.GroupRolesCreated = append(p.GroupRolesCreated, change)
+    }
 
-        if !exists {
-            log.Printf("    CREATING user role: %s", user)
-            if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE ROLE %s WITH LOGIN;", pgxQuoteIdentifier(user))); err != nil {
-                return fmt.Errorf("failed to create user role '%s': %w", user, err)
-            }
-            log.Printf("    GRANTING default group %s -> %s", defaultGroup, user)
-            if _, err := tx.Exec(ctx, fmt.Sprintf("GRANT %s TO %s;", pgxQuoteIdentifier(defaultGroup), pgxQuoteIdentifier(user))); err != nil {
-                return fmt.Errorf("failed to grant default role '%s' to new user '%s': %w", defaultGroup, user, err)
-            }
+    users := make([]string, 0, len(desired.Users))
+    for user := range desired.Users {
+        users = append(users, user)
+    }
+
+    usersToCreate, err := missingRoles(ctx, c.Pool, users)
+    if err != nil {
+        return nil, err
+    }
+    for _, user := range usersToCreate {
+        src := desired.Users[user]
+        p.RolesCreated = append(p.RolesCreated, plan.Change{Role: user, LDAPGroup: src.LDAPGroup, LDAPDN: src.DN, LDAPUser: src.Username})
+        p.Grants = append(p.Grants, plan.Change{Role: desired.DefaultGroup, Member: user, LDAPGroup: src.LDAPGroup, LDAPDN: src.DN, LDAPUser: src.Username})
+    }
+
+    // Privileges of the mapped roles do not depend on the user scope.
+    for pgRole, target := range desired.Roles {
+        if target.Privileges == nil {
+            continue
+        }
+        if err := planPrivileges(ctx, c.Pool, p, pgRole, target); err != nil {
+            return nil, err
         }
     }
-    return tx.Commit(ctx)
-}
 
-// SyncRoleMembership now ONLY manages memberships between pre-existing roles.
-// This is Phase 2 of the synchronization process.
-func (c *Client) SyncRoleMembership(ctx context.Context, pgRole string, ldapMembers []string, prefixes []string) error {
-    if len(prefixes) == 0 {
-        log.Printf("WARNING: Membership sync for role '%s' skipped because no 'AllowedUserPrefixes' are configured.", pgRole)
-        return nil
+    if desired.Scope.Empty() {
+        // Safety check: If no managed users are defined, do nothing to avoid accidentally wiping users.
+        log.Println("WARNING: Membership sync and deprovisioning skipped because neither 'allowed_user_prefixes' nor 'user_scope.include' is configured.")
+        p.Sort()
+        return p, nil
     }
 
-    tx, err := c.Pool.Begin(ctx)
+    // Existing roles that were not created by the sync are left alone entirely.
+    unowned, err := unownedRoles(ctx, c.Pool, desired.Ownership, users)
     if err != nil {
-        return fmt.Errorf("failed to begin transaction: %w", err)
+        return nil, err
+    }
+    if len(unowned) > 0 {
+        log.Printf("WARNING [%s]: Existing roles %v are not marked as managed and are skipped; use 'adopt' to take them over.", alias, unowned)
     }
-    defer tx.Rollback(ctx)
 
-    // --- Step 1: Get current MANAGED members of the role from Postgres ---
-    var whereClauses []string
-    args := []interface{}{pgRole} // $1 will be the pgRole
+    var existing []string
+    for _, user := range users {
+        if !slices.Contains(usersToCreate, user) && !slices.Contains(unowned, user) {
+            existing = append(existing, user)
+        }
+    }
 
-    for i, prefix := range prefixes {
-        whereClauses = append(whereClauses, fmt.Sprintf("u.rolname LIKE $%d", i+2))
-        args = append(args, prefix+"%")
+    // Roles disabled by an earlier deprovisioning are switched back on when their user
+    // returns, even if the database has since moved to the drop strategy.
+    if err := planReenable(ctx, c.Pool, p, existing, desired.Users); err != nil {
+        return nil, err
     }
 
-    query := fmt.Sprintf(`
-        SELECT u.rolname
-        FROM pg_catalog.pg_roles u
-        JOIN pg_catalog.pg_auth_members m ON (m.member = u.oid)
-        JOIN pg_catalog.pg_roles g ON (g.oid = m.roleid)
-        WHERE g.rolname = $1 AND (%s)`, strings.Join(whereClauses, " OR "))
+    // Attribute policies apply to every user role the sync manages, new or existing.
+    if err := planAttributes(ctx, c.Pool, p, desired, existing, usersToCreate); err != nil {
+        return nil, err
+    }
 
-    rows, err := tx.Query(ctx, query, args...)
-    if err != nil {
-        return fmt.Errorf("failed to query for current managed members of role '%s': %w", pgRole, err)
+    for pgRole, target := range desired.Roles {
+        members := target.Members
+        if len(unowned) > 0 {
+            members = maps.Clone(target.Members)
+            for _, user := range unowned {
+                delete(members, user)
+            }
+        }
+        usersToGrant, usersToRevoke, current, err := diffRoleMembership(ctx, c.Pool, pgRole, members, filter)
+        if err != nil {
+            return nil, err
+        }
+        p.CurrentMembers[pgRole] = current
+        for _, user := range usersToGrant {
+            src := target.Members[user]
+            p.Grants = append(p.Grants, plan.Change{Role: pgRole, Member: user, LDAPGroup: target.LDAPGroup, LDAPDN: src.DN, LDAPUser: src.Username})
+        }
+        for _, user := range usersToRevoke {
+            p.Revokes = append(p.Revokes, plan.Change{Role: pgRole, Member: user, LDAPGroup: target.LDAPGroup})
+        }
     }
-    pgManagedMembers, err := pgx.CollectRows(rows, pgx.RowTo[string])
+
+    if desired.SkipDeprovision {
+        p.Sort()
+        return p, nil
+    }
+
+    usersToDrop, current, err := staleUsers(ctx, c.Pool, desired.Users, desired.DefaultGroup, filter)
     if err != nil {
-        return fmt.Errorf("failed to collect current managed members for role '%s': %w", pgRole, err)
+        return nil, err
+    }
+    p.CurrentMembers[desired.DefaultGroup] = current
+    if err := planDeprovision(ctx, c.Pool, p, usersToDrop, desired.Deprovision, time.Now()); err != nil {
+        return nil, err
+    }
+    if desired.Deprovision.ReleasesOwned() {
+        if err := c.planOwned(ctx, p); err != nil {
+            return nil, err
+        }
     }
 
-    // --- Step 2: Calculate the difference ---
-    ldapMemberSet := make(map[string]bool, len(ldapMembers))
-    for _, member := range ldapMembers {
-        ldapMemberSet[member] = true
+    p.Sort()
+    return p, nil
+}
+
+// EnsureUsersExist creates the planned group and user roles in a single transaction, re-enables
+// returning users and grants new users the default group. This is Phase 1 of the
+// synchronization process.
+func (c *Client) EnsureUsersExist(ctx context.Context, p *plan.DatabasePlan) error {
+    tx, err := c.Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to begin user creation transaction: %w", err)
     }
+    defer tx.Rollback(ctx)
 
-    pgMemberSet := make(map[string]bool, len(pgManagedMembers))
-    for _, member := range pgManagedMembers {
-        pgMemberSet[member] = true
+    for _, change := range p.GroupRolesCreated {
+        log.Printf("    CREATING group role: %s", change.Role)
+        if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE ROLE %s WITH %s;", pgxQuoteIdentifier(change.Role), p.GroupRoleAttributes.Options())); err != nil {
+            return fmt.Errorf("failed to create group role '%s': %w", change.Role, err)
+        }
     }
+    for _, change := range p.RolesCreated {
+        log.Printf("    CREATING user role: %s", change.Role)
+        if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE ROLE %s WITH LOGIN;", pgxQuoteIdentifier(change.Role))); err != nil {
+            return fmt.Errorf("failed to create user role '%s': %w", change.Role, err)
+        }
+        if err := markRole(ctx, tx, p.Ownership, change.Role, change.LDAPDN); err != nil {
+            return err
+        }
+    }
+    for _, change := range p.Enables {
+        log.Printf("    RE-ENABLING user role: %s", change.Role)
+        if err := setLogin(ctx, tx, change.Role, true, time.Now()); err != nil {
+            return err
+        }
+    }
+    for _, change := range p.Attributes {
+        // Hard stop, whatever the plan says: these are never granted by the sync.
+        if slices.Contains(config.DeniedRoleAttributes, strings.ToUpper(change.Attribute)) {
+            return fmt.Errorf("refusing to change %s of role '%s'", change.Attribute, change.Role)
+        }
+        log.Printf("    %s", change.SQL)
+        if _, err := tx.Exec(ctx, change.SQL); err != nil {
+            return fmt.Errorf("failed to set %s of role '%s': %w", change.Attribute, change.Role, err)
+        }
+    }
+    for _, change := range p.Grants {
+        if change.Role != p.DefaultGroup {
+            continue
+        }
+        log.Printf("    GRANTING default group %s -> %s", change.Role, change.Member)
+        if _, err := tx.Exec(ctx, fmt.Sprintf("GRANT %s TO %s;", pgxQuoteIdentifier(change.Role), pgxQuoteIdentifier(change.Member))); err != nil {
+            return fmt.Errorf("failed to grant default role '%s' to new user '%s': %w", change.Role, change.Member, err)
+        }
+    }
+    return tx.Commit(ctx)
+}
+
+// SyncRoleMembership applies the planned GRANTs and REVOKEs for a single group role.
+// This is Phase 2 of the synchronization process.
+func (c *Client) SyncRoleMembership(ctx context.Context, p *plan.DatabasePlan, pgRole string) error {
+    grants, revokes := p.MembershipChanges(pgRole)
 
     var usersToGrant []string
     var usersToRevoke []string
 
-    for _, ldapUser := range ldapMembers {
-        if !pgMemberSet[ldapUser] {
-            usersToGrant = append(usersToGrant, ldapUser)
-        }
+    for _, change := range grants {
+        usersToGrant = append(usersToGrant, change.Member)
+    }
+    for _, change := range revokes {
+        usersToRevoke = append(usersToRevoke, change.Member)
     }
 
-    for _, pgUser := range pgManagedMembers {
-        if !ldapMemberSet[pgUser] {
-            usersToRevoke = append(usersToRevoke, pgUser)
-        }
+    tx, err := c.Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
     }
+    defer tx.Rollback(ctx)
 
-    // --- Step 3: Execute GRANT and REVOKE statements ---
+    // --- Execute GRANT and REVOKE statements ---
     // Use pgx.Identifier to safely quote all role and user names.
     pgRoleIdentifier := pgx.Identifier{pgRole}
 
@@ -174,12 +291,11 @@ func (c *Client) SyncRoleMembership(ctx context.Context, pgRole string, ldapMemb
     return tx.Commit(ctx)
 }
 
-// DeprovisionUsers removes users who are no longer in any valid LDAP groups.
+// DeprovisionUsers disables or drops the planned stale users, who are no longer in any valid LDAP groups.
 // This is Phase 3 of the synchronization process.
-func (c *Client) DeprovisionUsers(ctx context.Context, ldapUsers map[string]bool, groupName string, prefixes []string) error {
-    if len(prefixes) == 0 {
-        // Safety check: If no prefixes are defined, do nothing to avoid accidentally wiping users.
-        log.Println("WARNING: Deprovisioning skipped because no 'AllowedUserPrefixes' are configured.")
+func (c *Client) DeprovisionUsers(ctx context.Context, p *plan.DatabasePlan) error {
+    if len(p.Drops) == 0 && len(p.Disables) == 0 {
+        log.Println("No stale users to deprovision.")
         return nil
     }
 
@@ -189,61 +305,161 @@ func (c *Client) DeprovisionUsers(ctx context.Context, ldapUsers map[string]bool
     }
     defer tx.Rollback(ctx) // Rollback on any error
 
-    // Build the dynamic query to find all users in the target group THAT MATCH A MANAGED PREFIX.
-    var whereClauses []string
-    args := []interface{}{groupName} // $1 will be the groupName
+    var errs []error
+    if len(p.Drops) > 0 {
+        usersToDrop := make([]string, 0, len(p.Drops))
+        for _, change := range p.Drops {
+            usersToDrop = append(usersToDrop, change.Role)
+        }
+        log.Printf("Deprovisioning the following stale users: %v", usersToDrop)
+    }
+
+    // Disabled roles keep their objects and can be re-enabled; their memberships in
+    // the mapped roles have already been revoked in Phase 2.
+    for _, change := range p.Disables {
+        log.Printf("    DISABLING stale user: %s", change.Role)
+        if err := setLogin(ctx, tx, change.Role, false, time.Now()); err != nil {
+            return err
+        }
+    }
+
+    // Execute DROP ROLE commands for each user to be removed. Each drop runs in its own
+    // savepoint, so one failure does not abort the others. Roles whose objects are
+    // released first are dropped by ReleaseAndDrop instead.
+    if !p.Deprovision.ReleasesOwned() {
+        for _, change := range p.Drops {
+            user := change.Role
+            // pgx.Identifier safely quotes the username to prevent SQL injection.
+            dropUserSQL := fmt.Sprintf("DROP ROLE %s", pgx.Identifier{user}.Sanitize())
+            if err := execSavepoint(ctx, tx, dropUserSQL); err != nil {
+                // Log the error but continue trying to drop other users.
+                log.Printf("    ERROR: Failed to drop user '%s': %v", user, err)
+                errs = append(errs, fmt.Errorf("user '%s': %w", user, err))
+            } else {
+                log.Printf("    SUCCESS: Dropped user '%s'.", user)
+            }
+        }
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return err
+    }
+    return errors.Join(errs...)
+}
 
-    for i, prefix := range prefixes {
-        // Use LIKE with a wildcard to match the prefix.
-        whereClauses = append(whereClauses, fmt.Sprintf("u.rolname LIKE $%d", i+2))
-        args = append(args, prefix+"%")
+// execSavepoint runs a statement inside a savepoint of tx, rolling back only that
+// statement if it fails.
+func execSavepoint(ctx context.Context, tx pgx.Tx, sql string) error {
+    sp, err := tx.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    if _, err := sp.Exec(ctx, sql); err != nil {
+        sp.Rollback(ctx)
+        return err
+    }
+    return sp.Commit(ctx)
+}
+
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx, so the diff logic below
+// can run either read-only (planning) or inside the transaction that applies it.
+type querier interface {
+    Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+    QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// MissingRoles returns the roles that do not exist in the cluster.
+func (c *Client) MissingRoles(ctx context.Context, roles []string) ([]string, error) {
+    return missingRoles(ctx, c.Pool, roles)
+}
+
+// missingRoles returns the subset of users that do not yet exist as roles.
+func missingRoles(ctx context.Context, q querier, users []string) ([]string, error) {
+    var missing []string
+    for _, user := range users {
+        var exists bool
+        err := q.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1)", user).Scan(&exists)
+        if err != nil {
+            return nil, fmt.Errorf("failed to check for existence of role '%s': %w", user, err)
+        }
+        if !exists {
+            missing = append(missing, user)
+        }
     }
-    
+    return missing, nil
+}
+
+// managedMembers returns the members of groupName selected by filter: names in the managed
+// user scope and, if an ownership marker is configured, roles carrying it. The marker is
+// checked in SQL and the scope in Go, over the members returned.
+func managedMembers(ctx context.Context, q querier, groupName string, filter roleFilter) ([]string, error) {
+    condition, filterArgs := filter.sql(2)
+    args := append([]any{groupName}, filterArgs...) // $1 will be the groupName
+
     query := fmt.Sprintf(`
         SELECT u.rolname
         FROM pg_catalog.pg_roles u
         JOIN pg_catalog.pg_auth_members m ON (m.member = u.oid)
         JOIN pg_catalog.pg_roles g ON (g.oid = m.roleid)
-        WHERE g.rolname = $1 AND (%s)`, strings.Join(whereClauses, " OR "))
+        WHERE g.rolname = $1 AND %s`, condition)
 
-    // Fetch the managed users from Postgres that are candidates for deletion.
-    rows, err := tx.Query(ctx, query, args...)
+    rows, err := q.Query(ctx, query, args...)
     if err != nil {
-        return fmt.Errorf("failed to query for managed postgres users: %w", err)
+        return nil, fmt.Errorf("failed to query for current managed members of role '%s': %w", groupName, err)
     }
-    
-    pgManagedUsers, err := pgx.CollectRows(rows, pgx.RowTo[string])
+    members, err := pgx.CollectRows(rows, pgx.RowTo[string])
     if err != nil {
-        return fmt.Errorf("failed to collect managed user rows: %w", err)
+        return nil, fmt.Errorf("failed to collect current managed members for role '%s': %w", groupName, err)
     }
+    return slices.DeleteFunc(members, func(member string) bool { return !filter.match(member) }), nil
+}
 
-    // Determine which of the managed users no longer exist in LDAP.
-    var usersToDrop []string
-    for _, pgUser := range pgManagedUsers {
-        if _, existsInLdap := ldapUsers[pgUser]; !existsInLdap {
-            usersToDrop = append(usersToDrop, pgUser)
-        }
+// diffRoleMembership compares the LDAP members of pgRole with its current managed
+// members and returns the users to grant, the users to revoke and the number of
+// current managed members.
+func diffRoleMembership(ctx context.Context, q querier, pgRole string, ldapMembers map[string]plan.Source, filter roleFilter) ([]string, []string, int, error) {
+    pgManagedMembers, err := managedMembers(ctx, q, pgRole, filter)
+    if err != nil {
+        return nil, nil, 0, err
     }
 
-    if len(usersToDrop) == 0 {
-        log.Println("No stale users to deprovision.")
-        return tx.Commit(ctx) // Nothing to do, commit the (empty) transaction.
+    pgMemberSet := make(map[string]bool, len(pgManagedMembers))
+    for _, member := range pgManagedMembers {
+        pgMemberSet[member] = true
+    }
+
+    var usersToGrant []string
+    var usersToRevoke []string
+
+    for ldapUser := range ldapMembers {
+        if !pgMemberSet[ldapUser] {
+            usersToGrant = append(usersToGrant, ldapUser)
+        }
     }
 
-    // Execute DROP ROLE commands for each user to be removed.
-    log.Printf("Deprovisioning the following stale users: %v", usersToDrop)
-    for _, user := range usersToDrop {
-        // pgx.Identifier safely quotes the username to prevent SQL injection.
-        dropUserSQL := fmt.Sprintf("DROP ROLE %s", pgx.Identifier{user}.Sanitize())
-        if _, err := tx.Exec(ctx, dropUserSQL); err != nil {
-            // Log the error but continue trying to drop other users.
-            log.Printf("    ERROR: Failed to drop user '%s': %v", user, err)
-        } else {
-            log.Printf("    SUCCESS: Dropped user '%s'.", user)
+    for _, pgUser := range pgManagedMembers {
+        if _, inLdap := ldapMembers[pgUser]; !inLdap {
+            usersToRevoke = append(usersToRevoke, pgUser)
         }
     }
+    return usersToGrant, usersToRevoke, len(pgManagedMembers), nil
+}
 
-    return tx.Commit(ctx)
+// staleUsers returns the managed members of groupName that are no longer present in
+// LDAP, and the number of current managed members.
+func staleUsers(ctx context.Context, q querier, ldapUsers map[string]plan.Source, groupName string, filter roleFilter) ([]string, int, error) {
+    pgManagedUsers, err := managedMembers(ctx, q, groupName, filter)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    var usersToDrop []string
+    for _, pgUser := range pgManagedUsers {
+        if _, existsInLdap := ldapUsers[pgUser]; !existsInLdap {
+            usersToDrop = append(usersToDrop, pgUser)
+        }
+    }
+    return usersToDrop, len(pgManagedUsers), nil
 }
 
 // pgxQuoteIdentifier safely quotes a Postgres identifier to prevent SQL injection.
@@ -0,0 +1,169 @@
+// internal/postgres/deprovision.go
+
+package postgres
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
+    "github.com/jackc/pgx/v5"
+)
+
+// disabledAtKey records in the role comment when the sync disabled a role. Only roles
+// carrying it are re-enabled or dropped after the grace period, so a role disabled by
+// hand is never switched back on.
+const disabledAtKey = "disabled-at="
+
+// roleState is the part of a role's catalog entry that deprovisioning depends on.
+type roleState struct {
+    canLogin bool
+    comment  string
+}
+
+// roleStates returns the login flag and comment of every existing role in roles.
+func roleStates(ctx context.Context, q querier, roles []string) (map[string]roleState, error) {
+    states := make(map[string]roleState, len(roles))
+    if len(roles) == 0 {
+        return states, nil
+    }
+    rows, err := q.Query(ctx, `
+        SELECT rolname, rolcanlogin, coalesce(pg_catalog.shobj_description(oid, 'pg_authid'), '')
+        FROM pg_catalog.pg_roles
+        WHERE rolname = ANY($1)`, roles)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read role states: %w", err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var name string
+        var state roleState
+        if err := rows.Scan(&name, &state.canLogin, &state.comment); err != nil {
+            return nil, fmt.Errorf("failed to read role states: %w", err)
+        }
+        states[name] = state
+    }
+    return states, rows.Err()
+}
+
+// disabledAt returns when the sync disabled a role, as recorded in its comment.
+func disabledAt(comment string) (time.Time, bool) {
+    i := strings.Index(comment, disabledAtKey)
+    if i < 0 {
+        return time.Time{}, false
+    }
+    value, _, _ := strings.Cut(comment[i+len(disabledAtKey):], ";")
+    at, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+    if err != nil {
+        return time.Time{}, false
+    }
+    return at, true
+}
+
+// withoutDisabledAt removes the disabled-at entry from a role comment.
+func withoutDisabledAt(comment string) string {
+    i := strings.Index(comment, disabledAtKey)
+    if i < 0 {
+        return comment
+    }
+    rest := ""
+    if _, after, found := strings.Cut(comment[i:], ";"); found {
+        rest = strings.TrimSpace(after)
+    }
+    head := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(comment[:i]), ";"))
+    switch {
+    case head == "":
+        return rest
+    case rest == "":
+        return head
+    }
+    return head + "; " + rest
+}
+
+// planDeprovision decides, for every stale user, whether to drop or disable its role
+// according to the database's strategy.
+func planDeprovision(ctx context.Context, q querier, p *plan.DatabasePlan, stale []string, strategy config.DeprovisionConfig, now time.Time) error {
+    if strategy.Strategy == config.DeprovisionDrop {
+        for _, user := range stale {
+            p.Drops = append(p.Drops, plan.Change{Role: user})
+        }
+        return nil
+    }
+
+    states, err := roleStates(ctx, q, stale)
+    if err != nil {
+        return err
+    }
+    for _, user := range stale {
+        state := states[user]
+        if state.canLogin {
+            p.Disables = append(p.Disables, plan.Change{Role: user})
+            continue
+        }
+        // A role disabled by hand carries no stamp; it is neither stamped nor dropped.
+        since, stamped := disabledAt(state.comment)
+        if !stamped {
+            continue
+        }
+        if strategy.Strategy == config.DeprovisionDropAfter && now.Sub(since) >= strategy.GracePeriod {
+            p.Drops = append(p.Drops, plan.Change{Role: user})
+        }
+    }
+    return nil
+}
+
+// planReenable plans re-enabling the roles of users back in LDAP that the sync had disabled.
+func planReenable(ctx context.Context, q querier, p *plan.DatabasePlan, existing []string, users map[string]plan.Source) error {
+    states, err := roleStates(ctx, q, existing)
+    if err != nil {
+        return err
+    }
+    for _, user := range existing {
+        state, ok := states[user]
+        if !ok || state.canLogin {
+            continue
+        }
+        if _, stamped := disabledAt(state.comment); stamped {
+            src := users[user]
+            p.Enables = append(p.Enables, plan.Change{Role: user, LDAPGroup: src.LDAPGroup, LDAPDN: src.DN, LDAPUser: src.Username})
+        }
+    }
+    return nil
+}
+
+// setLogin switches a role's LOGIN attribute and records or clears the disabled-at
+// entry in its comment, keeping the rest of the comment, e.g. the ownership marker.
+func setLogin(ctx context.Context, tx pgx.Tx, role string, login bool, now time.Time) error {
+    var comment string
+    err := tx.QueryRow(ctx, `
+        SELECT coalesce(pg_catalog.shobj_description(oid, 'pg_authid'), '')
+        FROM pg_catalog.pg_roles WHERE rolname = $1`, role).Scan(&comment)
+    if err != nil {
+        return fmt.Errorf("failed to read comment of role '%s': %w", role, err)
+    }
+
+    attribute := "LOGIN"
+    comment = withoutDisabledAt(comment)
+    if !login {
+        attribute = "NOLOGIN"
+        if comment == "" {
+            comment = managedMarker
+        }
+        comment += "; " + disabledAtKey + now.UTC().Format(time.RFC3339)
+    }
+
+    if _, err := tx.Exec(ctx, fmt.Sprintf("ALTER ROLE %s %s", pgxQuoteIdentifier(role), attribute)); err != nil {
+        return fmt.Errorf("failed to set %s on role '%s': %w", attribute, role, err)
+    }
+    literal := "NULL"
+    if comment != "" {
+        literal = quoteLiteral(comment)
+    }
+    if _, err := tx.Exec(ctx, fmt.Sprintf("COMMENT ON ROLE %s IS %s", pgxQuoteIdentifier(role), literal)); err != nil {
+        return fmt.Errorf("failed to update comment of role '%s': %w", role, err)
+    }
+    return nil
+}
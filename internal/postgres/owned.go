@@ -0,0 +1,255 @@
+// internal/postgres/owned.go
+
+package postgres
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "slices"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
+    "github.com/jackc/pgx/v5"
+)
+
+// actionKept is the action of an object the configured cleanup does not release. A role
+// with such an object cannot be dropped.
+const actionKept = "kept"
+
+// planOwned lists, for every planned drop, the objects the role owns or holds privileges
+// on across the cluster, with the action the configured cleanup will take on each.
+func (c *Client) planOwned(ctx context.Context, p *plan.DatabasePlan) error {
+    if len(p.Drops) == 0 {
+        return nil
+    }
+    roles := make([]string, len(p.Drops))
+    for i, change := range p.Drops {
+        roles[i] = change.Role
+    }
+    owned, err := c.listOwned(ctx, roles, p.Deprovision)
+    if err != nil {
+        return err
+    }
+    for i := range p.Drops {
+        p.Drops[i].Objects = owned[p.Drops[i].Role]
+        if kept := keptObjects(p.Drops[i].Objects); kept > 0 {
+            log.Printf("WARNING [%s]: Role '%s' keeps %d object(s) or privilege(s) the configured cleanup does not release; it cannot be dropped.", p.Alias, p.Drops[i].Role, kept)
+        }
+    }
+    return nil
+}
+
+// ReleaseAndDrop runs REASSIGN OWNED and/or DROP OWNED for a planned drop in every
+// database of the cluster, then drops the role. The objects are listed again first, and
+// nothing is released unless the cleanup covers all of them, so a role is only released
+// when DROP ROLE can succeed. The other databases are released one transaction each; the
+// release in this database and DROP ROLE share a transaction.
+func (c *Client) ReleaseAndDrop(ctx context.Context, p *plan.DatabasePlan, change *plan.Change) error {
+    cleanup := p.Deprovision
+    owned, err := c.listOwned(ctx, []string{change.Role}, cleanup)
+    if err != nil {
+        return err
+    }
+    change.Objects = owned[change.Role]
+    if kept := keptObjects(change.Objects); kept > 0 {
+        return fmt.Errorf("%d object(s) or privilege(s) would be kept by the configured cleanup; nothing released, role not dropped", kept)
+    }
+
+    var databases []string
+    for _, object := range change.Objects {
+        if object.Database == "" {
+            log.Printf("    [shared] %s (%s of %s): %s", object.Object, object.Dependency, change.Role, object.Action)
+            continue
+        }
+        log.Printf("    [%s] %s (%s of %s): %s", object.Database, object.Object, object.Dependency, change.Role, object.Action)
+        if object.Database != c.config.DBName && !slices.Contains(databases, object.Database) {
+            databases = append(databases, object.Database)
+        }
+    }
+    for _, dbName := range databases {
+        if err := c.releaseOwnedIn(ctx, dbName, change.Role, cleanup); err != nil {
+            return fmt.Errorf("database '%s': %w", dbName, err)
+        }
+    }
+
+    tx, err := c.Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback(ctx)
+    if err := releaseOwned(ctx, tx, change.Role, cleanup); err != nil {
+        return fmt.Errorf("database '%s': %w", c.config.DBName, err)
+    }
+    if _, err := tx.Exec(ctx, "DROP ROLE "+pgxQuoteIdentifier(change.Role)); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+// releaseOwnedIn releases the objects of a role in another database of the cluster, in
+// a transaction of its own.
+func (c *Client) releaseOwnedIn(ctx context.Context, dbName, role string, cleanup config.DeprovisionConfig) error {
+    conn, err := pgx.Connect(ctx, c.connString(dbName))
+    if err != nil {
+        return fmt.Errorf("unable to connect: %w", err)
+    }
+    defer conn.Close(ctx)
+
+    tx, err := conn.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback(ctx)
+    if err := releaseOwned(ctx, tx, role, cleanup); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+// releaseOwned runs REASSIGN OWNED then DROP OWNED in the database of tx.
+func releaseOwned(ctx context.Context, tx pgx.Tx, role string, cleanup config.DeprovisionConfig) error {
+    if cleanup.ReassignOwnedTo != "" {
+        sql := fmt.Sprintf("REASSIGN OWNED BY %s TO %s", pgxQuoteIdentifier(role), pgxQuoteIdentifier(cleanup.ReassignOwnedTo))
+        if _, err := tx.Exec(ctx, sql); err != nil {
+            return fmt.Errorf("REASSIGN OWNED failed: %w", err)
+        }
+    }
+    if cleanup.DropOwned {
+        if _, err := tx.Exec(ctx, fmt.Sprintf("DROP OWNED BY %s", pgxQuoteIdentifier(role))); err != nil {
+            return fmt.Errorf("DROP OWNED failed: %w", err)
+        }
+    }
+    return nil
+}
+
+// listOwned returns, by role, the objects the roles own or hold privileges on across
+// the cluster. Objects in databases that allow connections are described from within
+// each database; shared objects (databases, tablespaces, ...) and objects in databases
+// the sync cannot connect to come from the shared pg_shdepend catalog. The latter are
+// always kept, as neither REASSIGN OWNED nor DROP OWNED can reach them. Nothing is
+// changed.
+func (c *Client) listOwned(ctx context.Context, roles []string, cleanup config.DeprovisionConfig) (map[string][]plan.OwnedObject, error) {
+    owned := make(map[string][]plan.OwnedObject)
+    collect := func(rows pgx.Rows, reachable bool) error {
+        var role, dbName, object, deptype string
+        _, err := pgx.ForEachRow(rows, []any{&role, &dbName, &object, &deptype}, func() error {
+            found := describeOwned(dbName, object, deptype, cleanup)
+            if !reachable && dbName != "" {
+                found.Action = actionKept
+            }
+            owned[role] = append(owned[role], found)
+            return nil
+        })
+        return err
+    }
+
+    rows, err := c.Pool.Query(ctx, `
+        SELECT r.rolname, COALESCE(db.datname, ''),
+               CASE WHEN d.dbid = 0 THEN pg_catalog.pg_describe_object(d.classid, d.objid, d.objsubid)
+                    ELSE d.classid::regclass::text || ' ' || d.objid END,
+               d.deptype::text
+        FROM pg_catalog.pg_shdepend d
+        JOIN pg_catalog.pg_roles r ON r.oid = d.refobjid
+        LEFT JOIN pg_catalog.pg_database db ON db.oid = d.dbid
+        WHERE d.refclassid = 'pg_catalog.pg_authid'::regclass AND r.rolname = ANY($1)
+          AND (d.dbid = 0 OR NOT (db.datallowconn AND NOT db.datistemplate))
+        ORDER BY 1, 2, 3`, roles)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list shared objects of roles %v: %w", roles, err)
+    }
+    if err := collect(rows, false); err != nil {
+        return nil, fmt.Errorf("failed to list shared objects of roles %v: %w", roles, err)
+    }
+
+    rows, err = c.Pool.Query(ctx, `
+        SELECT db.datname
+        FROM pg_catalog.pg_database db
+        WHERE db.datallowconn AND NOT db.datistemplate
+          AND EXISTS (SELECT 1 FROM pg_catalog.pg_shdepend d
+                      JOIN pg_catalog.pg_roles r ON r.oid = d.refobjid
+                      WHERE d.dbid = db.oid AND d.refclassid = 'pg_catalog.pg_authid'::regclass
+                        AND r.rolname = ANY($1))
+        ORDER BY 1`, roles)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list databases: %w", err)
+    }
+    databases, err := pgx.CollectRows(rows, pgx.RowTo[string])
+    if err != nil {
+        return nil, fmt.Errorf("failed to list databases: %w", err)
+    }
+
+    for _, dbName := range databases {
+        if err := c.inDatabase(ctx, dbName, func(q querier) error {
+            rows, err := q.Query(ctx, `
+                SELECT r.rolname, pg_catalog.current_database(),
+                       pg_catalog.pg_describe_object(d.classid, d.objid, d.objsubid), d.deptype::text
+                FROM pg_catalog.pg_shdepend d
+                JOIN pg_catalog.pg_roles r ON r.oid = d.refobjid
+                JOIN pg_catalog.pg_database db ON db.oid = d.dbid
+                WHERE db.datname = pg_catalog.current_database()
+                  AND d.refclassid = 'pg_catalog.pg_authid'::regclass AND r.rolname = ANY($1)
+                ORDER BY 1, 3`, roles)
+            if err != nil {
+                return err
+            }
+            return collect(rows, true)
+        }); err != nil {
+            return nil, fmt.Errorf("database '%s': failed to list objects of roles %v: %w", dbName, roles, err)
+        }
+    }
+    return owned, nil
+}
+
+// inDatabase runs fn against a database of the cluster: the pool for the client's own
+// database, a short-lived connection for any other.
+func (c *Client) inDatabase(ctx context.Context, dbName string, fn func(q querier) error) error {
+    if dbName == c.config.DBName {
+        return fn(c.Pool)
+    }
+    conn, err := pgx.Connect(ctx, c.connString(dbName))
+    if err != nil {
+        return fmt.Errorf("unable to connect: %w", err)
+    }
+    defer conn.Close(ctx)
+    return fn(conn)
+}
+
+// keptObjects counts the objects the configured cleanup leaves in place.
+func keptObjects(objects []plan.OwnedObject) int {
+    kept := 0
+    for _, object := range objects {
+        if object.Action == actionKept {
+            kept++
+        }
+    }
+    return kept
+}
+
+// describeOwned classifies a pg_shdepend entry and names the action taken on it.
+// REASSIGN OWNED runs first, so DROP OWNED only drops what it did not reassign. An
+// empty dbName is a shared object, which DROP OWNED never drops.
+func describeOwned(dbName, object, deptype string, cleanup config.DeprovisionConfig) plan.OwnedObject {
+    owned := plan.OwnedObject{Database: dbName, Object: object, Action: actionKept}
+    switch deptype {
+    case "o":
+        owned.Dependency = "owner"
+        switch {
+        case cleanup.ReassignOwnedTo != "":
+            owned.Action = "reassigned to " + cleanup.ReassignOwnedTo
+        case cleanup.DropOwned && dbName != "":
+            owned.Action = "dropped"
+        }
+    case "r":
+        owned.Dependency = "policy"
+        if cleanup.DropOwned {
+            owned.Action = "removed from policy"
+        }
+    default: // "a" (ACL) and "i" (initial privileges)
+        owned.Dependency = "privilege"
+        if cleanup.DropOwned {
+            owned.Action = "revoked"
+        }
+    }
+    return owned
+}
@@ -0,0 +1,217 @@
+// internal/postgres/ownership.go
+
+package postgres
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+    "github.com/Dataloh/pg-ldap-sync/internal/scope"
+    "github.com/jackc/pgx/v5"
+)
+
+// managedMarker starts the comment or security label of every role owned by the sync.
+const managedMarker = "managed-by: pg-ldap-sync"
+
+// markerText returns the marker of a role, naming its source LDAP entry when known.
+func markerText(dn string) string {
+    if dn == "" {
+        return managedMarker
+    }
+    return managedMarker + "; dn=" + dn
+}
+
+// markRole records that the sync owns a role, using the configured marker. An existing
+// comment or label is kept after the marker, so the role only gains the marker.
+func markRole(ctx context.Context, tx pgx.Tx, ownership config.OwnershipConfig, role, dn string) error {
+    if ownership.Marker == "" {
+        return nil
+    }
+    existing, err := roleMarks(ctx, tx, ownership, []string{role})
+    if err != nil {
+        return err
+    }
+    text := markerText(dn)
+    if previous := existing[role]; previous != "" {
+        text += "; " + previous
+    }
+
+    var sql string
+    switch ownership.Marker {
+    case config.OwnershipComment:
+        sql = fmt.Sprintf("COMMENT ON ROLE %s IS %s", pgxQuoteIdentifier(role), quoteLiteral(text))
+    case config.OwnershipSecurityLabel:
+        sql = fmt.Sprintf("SECURITY LABEL FOR %s ON ROLE %s IS %s",
+            pgxQuoteIdentifier(ownership.Provider), pgxQuoteIdentifier(role), quoteLiteral(text))
+    default:
+        return nil
+    }
+    if _, err := tx.Exec(ctx, sql); err != nil {
+        return fmt.Errorf("failed to mark role '%s' as managed: %w", role, err)
+    }
+    return nil
+}
+
+// roleMarks returns the current comment, or security label of the configured provider,
+// of every role in roles that has one.
+func roleMarks(ctx context.Context, q querier, ownership config.OwnershipConfig, roles []string) (map[string]string, error) {
+    var rows pgx.Rows
+    var err error
+    switch ownership.Marker {
+    case config.OwnershipComment:
+        rows, err = q.Query(ctx, `
+            SELECT rolname, coalesce(pg_catalog.shobj_description(oid, 'pg_authid'), '')
+            FROM pg_catalog.pg_roles WHERE rolname = ANY($1)`, roles)
+    case config.OwnershipSecurityLabel:
+        rows, err = q.Query(ctx, `
+            SELECT r.rolname, coalesce(l.label, '')
+            FROM pg_catalog.pg_roles r
+            LEFT JOIN pg_catalog.pg_shseclabel l
+              ON l.objoid = r.oid AND l.classoid = 'pg_catalog.pg_authid'::regclass AND l.provider = $2
+            WHERE r.rolname = ANY($1)`, roles, ownership.Provider)
+    default:
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read comments of roles %v: %w", roles, err)
+    }
+    marks := make(map[string]string)
+    var role, mark string
+    _, err = pgx.ForEachRow(rows, []any{&role, &mark}, func() error {
+        if mark != "" {
+            marks[role] = mark
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to read comments of roles %v: %w", roles, err)
+    }
+    return marks, nil
+}
+
+// ownedSQL returns a condition that is true for roles carrying the marker, given the
+// column holding the role OID, with query parameters numbered from firstArg.
+func ownedSQL(ownership config.OwnershipConfig, oidColumn string, firstArg int) (string, []any) {
+    switch ownership.Marker {
+    case config.OwnershipComment:
+        return fmt.Sprintf("starts_with(coalesce(pg_catalog.shobj_description(%s, 'pg_authid'), ''), $%d)", oidColumn, firstArg),
+            []any{managedMarker}
+    case config.OwnershipSecurityLabel:
+        return fmt.Sprintf(`EXISTS (SELECT 1 FROM pg_catalog.pg_shseclabel l
+            WHERE l.objoid = %s AND l.classoid = 'pg_catalog.pg_authid'::regclass
+              AND l.provider = $%d AND starts_with(l.label, $%d))`, oidColumn, firstArg, firstArg+1),
+            []any{ownership.Provider, managedMarker}
+    default:
+        return "true", nil
+    }
+}
+
+// roleFilter selects the roles the sync may revoke or drop: role names in the user
+// scope that, when an ownership marker is configured, also carry the marker.
+type roleFilter struct {
+    scope     *scope.Matcher
+    ownership config.OwnershipConfig
+}
+
+// sql returns the ownership part of the filter as a condition on the pg_roles alias
+// "u", with query parameters numbered from firstArg. The scope is only evaluated in Go,
+// by match, as PostgreSQL's regular expressions differ from Go's.
+func (f roleFilter) sql(firstArg int) (string, []any) {
+    return ownedSQL(f.ownership, "u.oid", firstArg)
+}
+
+// match reports whether a role name is in the user scope.
+func (f roleFilter) match(name string) bool {
+    return f.scope.Match(name)
+}
+
+// unownedRoles returns the subset of existing roles that do not carry the ownership marker.
+func unownedRoles(ctx context.Context, q querier, ownership config.OwnershipConfig, roles []string) ([]string, error) {
+    if ownership.Marker == "" || len(roles) == 0 {
+        return nil, nil
+    }
+    owned, args := ownedSQL(ownership, "u.oid", 2)
+    query := fmt.Sprintf("SELECT u.rolname FROM pg_catalog.pg_roles u WHERE u.rolname = ANY($1) AND NOT %s ORDER BY 1", owned)
+    rows, err := q.Query(ctx, query, append([]any{roles}, args...)...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to check ownership of existing roles: %w", err)
+    }
+    unowned, err := pgx.CollectRows(rows, pgx.RowTo[string])
+    if err != nil {
+        return nil, fmt.Errorf("failed to collect unowned roles: %w", err)
+    }
+    return unowned, nil
+}
+
+// Adoption is a role marked, or to be marked, as owned by Adopt.
+type Adoption struct {
+    Role string
+    // Existing is the comment or security label the role already had. It is kept after
+    // the marker.
+    Existing string
+}
+
+// Adopt marks the roles that the sync used to manage by name alone, i.e. members of
+// defaultGroup within the user scope, as owned. It returns the adopted roles. With
+// dryRun set, nothing is changed and the roles that would be adopted are returned.
+func (c *Client) Adopt(ctx context.Context, defaultGroup string, userScope *scope.Matcher, ownership config.OwnershipConfig, dryRun bool) ([]Adoption, error) {
+    if ownership.Marker == "" {
+        return nil, fmt.Errorf("no ownership marker is configured")
+    }
+
+    candidates, err := managedMembers(ctx, c.Pool, defaultGroup, roleFilter{scope: userScope})
+    if err != nil {
+        return nil, err
+    }
+    unowned, err := unownedRoles(ctx, c.Pool, ownership, candidates)
+    if err != nil {
+        return nil, err
+    }
+    if len(unowned) == 0 {
+        return nil, nil
+    }
+    existing, err := roleMarks(ctx, c.Pool, ownership, unowned)
+    if err != nil {
+        return nil, err
+    }
+    adoptions := make([]Adoption, len(unowned))
+    for i, role := range unowned {
+        adoptions[i] = Adoption{Role: role, Existing: existing[role]}
+    }
+    if dryRun {
+        return adoptions, nil
+    }
+
+    tx, err := c.Pool.Begin(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin adoption transaction: %w", err)
+    }
+    defer tx.Rollback(ctx)
+
+    for _, adoption := range adoptions {
+        if adoption.Existing != "" {
+            log.Printf("    ADOPTING role: %s (keeping its comment %q after the marker)", adoption.Role, adoption.Existing)
+        } else {
+            log.Printf("    ADOPTING role: %s", adoption.Role)
+        }
+        if err := markRole(ctx, tx, ownership, adoption.Role, ""); err != nil {
+            return nil, err
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return adoptions, nil
+}
+
+// quoteLiteral quotes a string as an SQL literal, like PostgreSQL's quote_literal.
+func quoteLiteral(s string) string {
+    quoted := "'" + strings.ReplaceAll(s, "'", "''") + "'"
+    if strings.Contains(s, `\`) {
+        return "E" + strings.ReplaceAll(quoted, `\`, `\\`)
+    }
+    return quoted
+}
@@ -0,0 +1,191 @@
+// internal/postgres/privileges.go
+
+package postgres
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "slices"
+    "strings"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/plan"
+    "github.com/jackc/pgx/v5"
+)
+
+// Catalog queries returning the privileges a role holds directly on an object. An ACL
+// that was never changed is NULL and stands for the built-in defaults, hence acldefault.
+// Objects owned by the role are left out, as an owner holds every privilege implicitly.
+const (
+    databasePrivilegesSQL = `
+        SELECT a.privilege_type
+        FROM pg_catalog.pg_database d,
+             pg_catalog.aclexplode(COALESCE(d.datacl, pg_catalog.acldefault('d', d.datdba))) a
+        WHERE d.datname = pg_catalog.current_database()
+          AND a.grantee = (SELECT oid FROM pg_catalog.pg_roles WHERE rolname = $1)`
+
+    schemaPrivilegesSQL = `
+        SELECT a.privilege_type
+        FROM pg_catalog.pg_namespace n,
+             pg_catalog.aclexplode(COALESCE(n.nspacl, pg_catalog.acldefault('n', n.nspowner))) a
+        WHERE n.nspname = $2
+          AND a.grantee = (SELECT oid FROM pg_catalog.pg_roles WHERE rolname = $1)`
+
+    tablePrivilegesSQL = `
+        SELECT c.relname, ARRAY(
+            SELECT a.privilege_type
+            FROM pg_catalog.aclexplode(COALESCE(c.relacl, pg_catalog.acldefault('r', c.relowner))) a
+            WHERE a.grantee = (SELECT oid FROM pg_catalog.pg_roles WHERE rolname = $1))
+        FROM pg_catalog.pg_class c
+        JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+        WHERE n.nspname = $2 AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+          AND c.relowner <> COALESCE((SELECT oid FROM pg_catalog.pg_roles WHERE rolname = $1), 0)
+        ORDER BY c.relname`
+
+    defaultPrivilegesSQL = `
+        SELECT a.privilege_type
+        FROM pg_catalog.pg_default_acl d
+        JOIN pg_catalog.pg_namespace n ON n.oid = d.defaclnamespace,
+             pg_catalog.aclexplode(d.defaclacl) a
+        WHERE n.nspname = $2 AND d.defaclobjtype = 'r'
+          AND d.defaclrole = (SELECT oid FROM pg_catalog.pg_roles WHERE rolname = COALESCE(NULLIF($3, ''), current_user))
+          AND a.grantee = (SELECT oid FROM pg_catalog.pg_roles WHERE rolname = $1)`
+)
+
+// planPrivileges adds the grants and revokes that bring the privileges of pgRole on the
+// database and on each configured schema in line with the configuration.
+func planPrivileges(ctx context.Context, q querier, p *plan.DatabasePlan, pgRole string, target plan.RoleTarget) error {
+    privileges := target.Privileges
+    // add plans "[prefix] GRANT ... ON on TO role" and "[prefix] REVOKE ... ON on FROM role"
+    // statements for the difference between want and have.
+    add := func(object, prefix, on string, want, have []string) {
+        grant, revoke := diffPrivileges(want, have)
+        role := pgxQuoteIdentifier(pgRole)
+        if len(grant) > 0 {
+            p.Privileges = append(p.Privileges, plan.PrivilegeChange{
+                Role: pgRole, LDAPGroup: target.LDAPGroup, Action: "grant", Privileges: grant, Object: object,
+                SQL: prefix + "GRANT " + strings.Join(grant, ", ") + " ON " + on + " TO " + role,
+            })
+        }
+        if len(revoke) > 0 {
+            p.Privileges = append(p.Privileges, plan.PrivilegeChange{
+                Role: pgRole, LDAPGroup: target.LDAPGroup, Action: "revoke", Privileges: revoke, Object: object,
+                SQL: prefix + "REVOKE " + strings.Join(revoke, ", ") + " ON " + on + " FROM " + role,
+            })
+        }
+    }
+
+    var dbName, dbOwner string
+    err := q.QueryRow(ctx, `
+        SELECT d.datname, pg_catalog.pg_get_userbyid(d.datdba)
+        FROM pg_catalog.pg_database d
+        WHERE d.datname = pg_catalog.current_database()`).Scan(&dbName, &dbOwner)
+    if err != nil {
+        return fmt.Errorf("failed to read the current database: %w", err)
+    }
+    if dbOwner != pgRole {
+        have, err := queryPrivileges(ctx, q, databasePrivilegesSQL, pgRole)
+        if err != nil {
+            return fmt.Errorf("failed to read database privileges of role '%s': %w", pgRole, err)
+        }
+        add("DATABASE "+dbName, "", "DATABASE "+pgxQuoteIdentifier(dbName), privileges.Database, have)
+    }
+
+    for _, schema := range privileges.Schemas {
+        var schemaOwner string
+        err := q.QueryRow(ctx, "SELECT pg_catalog.pg_get_userbyid(nspowner) FROM pg_catalog.pg_namespace WHERE nspname = $1", schema.Name).Scan(&schemaOwner)
+        if errors.Is(err, pgx.ErrNoRows) {
+            log.Printf("WARNING [%s]: Schema '%s' configured for role '%s' does not exist; skipped.", p.Alias, schema.Name, pgRole)
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("failed to look up schema '%s': %w", schema.Name, err)
+        }
+        quotedSchema := pgxQuoteIdentifier(schema.Name)
+
+        if schemaOwner != pgRole {
+            have, err := queryPrivileges(ctx, q, schemaPrivilegesSQL, pgRole, schema.Name)
+            if err != nil {
+                return fmt.Errorf("failed to read privileges of role '%s' on schema '%s': %w", pgRole, schema.Name, err)
+            }
+            add("SCHEMA "+schema.Name, "", "SCHEMA "+quotedSchema, schema.Schema, have)
+        }
+
+        rows, err := q.Query(ctx, tablePrivilegesSQL, pgRole, schema.Name)
+        if err != nil {
+            return fmt.Errorf("failed to read privileges of role '%s' on tables in schema '%s': %w", pgRole, schema.Name, err)
+        }
+        var table string
+        var tableHave []string
+        _, err = pgx.ForEachRow(rows, []any{&table, &tableHave}, func() error {
+            add("TABLE "+schema.Name+"."+table, "", "TABLE "+quotedSchema+"."+pgxQuoteIdentifier(table), schema.Tables, tableHave)
+            return nil
+        })
+        if err != nil {
+            return fmt.Errorf("failed to read privileges of role '%s' on tables in schema '%s': %w", pgRole, schema.Name, err)
+        }
+
+        have, err := queryPrivileges(ctx, q, defaultPrivilegesSQL, pgRole, schema.Name, schema.DefaultOwner)
+        if err != nil {
+            return fmt.Errorf("failed to read default privileges of role '%s' in schema '%s': %w", pgRole, schema.Name, err)
+        }
+        forRole, object := "", "DEFAULT PRIVILEGES IN SCHEMA "+schema.Name
+        if schema.DefaultOwner != "" {
+            forRole = "FOR ROLE " + pgxQuoteIdentifier(schema.DefaultOwner) + " "
+            object = "DEFAULT PRIVILEGES FOR ROLE " + schema.DefaultOwner + " IN SCHEMA " + schema.Name
+        }
+        add(object, "ALTER DEFAULT PRIVILEGES "+forRole+"IN SCHEMA "+quotedSchema+" ", "TABLES", schema.DefaultTables, have)
+    }
+    return nil
+}
+
+// diffPrivileges returns the privileges in want but not in have, and those in have but
+// not in want, both sorted.
+func diffPrivileges(want, have []string) ([]string, []string) {
+    var grant, revoke []string
+    for _, privilege := range want {
+        if !slices.Contains(have, privilege) {
+            grant = append(grant, privilege)
+        }
+    }
+    for _, privilege := range have {
+        if !slices.Contains(want, privilege) && !slices.Contains(revoke, privilege) {
+            revoke = append(revoke, privilege)
+        }
+    }
+    slices.Sort(grant)
+    slices.Sort(revoke)
+    return grant, revoke
+}
+
+// queryPrivileges runs a catalog query returning one privilege name per row.
+func queryPrivileges(ctx context.Context, q querier, sql string, args ...any) ([]string, error) {
+    rows, err := q.Query(ctx, sql, args...)
+    if err != nil {
+        return nil, err
+    }
+    return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// SyncPrivileges applies the planned privilege changes of the mapped roles in a single
+// transaction.
+func (c *Client) SyncPrivileges(ctx context.Context, p *plan.DatabasePlan) error {
+    if len(p.Privileges) == 0 {
+        return nil
+    }
+
+    tx, err := c.Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to begin privileges transaction: %w", err)
+    }
+    defer tx.Rollback(ctx)
+
+    for _, change := range p.Privileges {
+        log.Printf("    %s", change.SQL)
+        if _, err := tx.Exec(ctx, change.SQL); err != nil {
+            return fmt.Errorf("failed to %s %v on %s for role '%s': %w", change.Action, change.Privileges, change.Object, change.Role, err)
+        }
+    }
+    return tx.Commit(ctx)
+}
@@ -0,0 +1,95 @@
+// internal/scope/scope.go
+
+package scope
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+)
+
+// Matcher decides which role names are managed by the sync. Every rule is turned into
+// a Go regular expression; globs and prefixes only produce anchors, `.`, `.*` and
+// escaped literals, so `_` and `%` are always literal. Roles found in PostgreSQL are
+// matched in Go too, never with PostgreSQL's `~`, whose syntax differs.
+type Matcher struct {
+    include []*regexp.Regexp
+    exclude []*regexp.Regexp
+}
+
+// New builds the matcher for a sync policy. A role name is managed if it starts with
+// one of the allowed prefixes or matches an include pattern, and matches no exclude pattern.
+func New(policy config.SyncPolicy) (*Matcher, error) {
+    m := &Matcher{}
+    for _, prefix := range policy.AllowedUserPrefixes {
+        m.include = append(m.include, regexp.MustCompile("^"+regexp.QuoteMeta(prefix)))
+    }
+    for _, p := range policy.UserScope.Include {
+        re, err := compile(p)
+        if err != nil {
+            return nil, err
+        }
+        m.include = append(m.include, re)
+    }
+    for _, p := range policy.UserScope.Exclude {
+        re, err := compile(p)
+        if err != nil {
+            return nil, err
+        }
+        m.exclude = append(m.exclude, re)
+    }
+    return m, nil
+}
+
+// compile turns a glob or regex pattern into a regular expression.
+func compile(p config.Pattern) (*regexp.Regexp, error) {
+    expr := p.Regex
+    if p.Glob != "" {
+        expr = globToRegex(p.Glob)
+    }
+    re, err := regexp.Compile(expr)
+    if err != nil {
+        return nil, fmt.Errorf("invalid user scope pattern '%s': %w", expr, err)
+    }
+    return re, nil
+}
+
+// globToRegex converts a glob, where '*' matches any run of characters and '?' any
+// single character, into an anchored regular expression. Everything else is literal.
+func globToRegex(glob string) string {
+    var expr strings.Builder
+    expr.WriteString("^")
+    for _, r := range glob {
+        switch r {
+        case '*':
+            expr.WriteString(".*")
+        case '?':
+            expr.WriteString(".")
+        default:
+            expr.WriteString(regexp.QuoteMeta(string(r)))
+        }
+    }
+    expr.WriteString("$")
+    return expr.String()
+}
+
+// Empty reports whether no role name can be managed, i.e. no prefix or include pattern is set.
+func (m *Matcher) Empty() bool {
+    return len(m.include) == 0
+}
+
+// Match reports whether a role name is managed.
+func (m *Matcher) Match(name string) bool {
+    return matchAny(m.include, name) && !matchAny(m.exclude, name)
+}
+
+func matchAny(exprs []*regexp.Regexp, name string) bool {
+    for _, re := range exprs {
+        if re.MatchString(name) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,123 @@
+// internal/scope/scope_test.go
+
+package scope
+
+import (
+    "testing"
+
+    "github.com/Dataloh/pg-ldap-sync/internal/config"
+)
+
+func TestMatch(t *testing.T) {
+    tests := []struct {
+        name   string
+        policy config.SyncPolicy
+        match  map[string]bool
+    }{
+        {
+            name:   "prefix is literal",
+            policy: config.SyncPolicy{AllowedUserPrefixes: []string{"nc_", "a.b"}},
+            match: map[string]bool{
+                "nc_jdoe":   true,
+                "nc_":       true,
+                "ncxjdoe":   false,
+                "x_nc_jdoe": false,
+                "a.bc":      true,
+                "axbc":      false,
+            },
+        },
+        {
+            name:   "glob matches the whole name",
+            policy: config.SyncPolicy{UserScope: config.UserScope{Include: []config.Pattern{{Glob: "svc_??_*"}}}},
+            match: map[string]bool{
+                "svc_ab_reporting": true,
+                "svc_ab_":          true,
+                "svc_a_reporting":  false,
+                "xsvc_ab_x":        false,
+                "svcxabx":          false,
+            },
+        },
+        {
+            name:   "glob treats _ % and . literally",
+            policy: config.SyncPolicy{UserScope: config.UserScope{Include: []config.Pattern{{Glob: "a_%.*"}}}},
+            match: map[string]bool{
+                "a_%.x":  true,
+                "a_%.":   true,
+                "ab%.x":  false,
+                "a_xyx":  false,
+                "a_%x.x": false,
+            },
+        },
+        {
+            name:   "regex is unanchored unless anchored",
+            policy: config.SyncPolicy{UserScope: config.UserScope{Include: []config.Pattern{{Regex: "admin_nc_[a-z]+$"}}}},
+            match: map[string]bool{
+                "admin_nc_asmith":  true,
+                "x_admin_nc_smith": true,
+                "admin_nc_smith1":  false,
+                "admin_nc_":        false,
+            },
+        },
+        {
+            name: "exclude wins over include",
+            policy: config.SyncPolicy{
+                AllowedUserPrefixes: []string{"nc_"},
+                UserScope: config.UserScope{
+                    Include: []config.Pattern{{Regex: "^svc_"}},
+                    Exclude: []config.Pattern{{Glob: "nc_break_glass*"}, {Regex: "_test$"}},
+                },
+            },
+            match: map[string]bool{
+                "nc_jdoe":          true,
+                "nc_break_glass":   false,
+                "nc_break_glass_2": false,
+                "svc_reporting":    true,
+                "svc_test":         false,
+                "other":            false,
+            },
+        },
+        {
+            name:   "empty scope matches nothing",
+            policy: config.SyncPolicy{},
+            match: map[string]bool{
+                "nc_jdoe": false,
+                "":        false,
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            m, err := New(tt.policy)
+            if err != nil {
+                t.Fatalf("New: %v", err)
+            }
+            for name, want := range tt.match {
+                if got := m.Match(name); got != want {
+                    t.Errorf("Match(%q) = %v, want %v", name, got, want)
+                }
+            }
+        })
+    }
+}
+
+func TestNewRejectsInvalidRegex(t *testing.T) {
+    policy := config.SyncPolicy{UserScope: config.UserScope{Include: []config.Pattern{{Regex: "nc_(["}}}}
+    if _, err := New(policy); err == nil {
+        t.Fatal("New accepted an invalid regular expression")
+    }
+}
+
+func TestGlobToRegex(t *testing.T) {
+    tests := map[string]string{
+        "nc_*":     `^nc_.*$`,
+        "svc_??":   `^svc_..$`,
+        "a.b+c":    `^a\.b\+c$`,
+        "(x)|[y]$": `^\(x\)\|\[y\]\$$`,
+    }
+    for glob, want := range tests {
+        if got := globToRegex(glob); got != want {
+            t.Errorf("globToRegex(%q) = %q, want %q", glob, got, want)
+        }
+    }
+}
@@ -14,20 +14,47 @@ log_success() {
   echo -e "\033[1;32m✅ $1\033[0m"
 }
 
-run_sync_job() {
-  log_step "Running Go sync job..."
+# run_sync_container runs the sync image once. An optional config file replaces the
+# config.yml baked into the image.
+run_sync_container() {
+  local config_file=${1:-}
   export PG_PASSWORD="supersecretpassword"
   export LDAP_BIND_PASSWORD="adminpassword"
 
+  local config_args=(-e CFG_PATH)
+  if [ -n "$config_file" ]; then
+    config_args=(-v "$(pwd)/$config_file:/tmp/config.yml:ro" -e CFG_PATH=/tmp/config.yml)
+  fi
+
   docker run \
       -e PG_PASSWORD \
       -e LDAP_BIND_DN \
       -e LDAP_BIND_PASSWORD \
-      -e CFG_PATH \
+      "${config_args[@]}" \
       --network pg-ldap-sync_dev-net \
       --rm \
       pg-ldap-sync:latest
-  }
+}
+
+run_sync_job() {
+  log_step "Running Go sync job..."
+  run_sync_container "${1:-}"
+}
+
+# run_sync_job_expecting_failure runs the sync, which must exit non-zero, and checks
+# that its log contains the given message.
+run_sync_job_expecting_failure() {
+  local expected=$1
+  log_step "Running Go sync job (expecting failure)..."
+  if run_sync_container > sync_output.log.tmp 2>&1; then
+    cat sync_output.log.tmp
+    echo "FAILED: the sync job succeeded but was expected to fail"
+    return 1
+  fi
+  cat sync_output.log.tmp
+  echo "VERIFYING: Sync output should contain '$expected'"
+  grep -q "$expected" sync_output.log.tmp
+}
 
 # ... (All verify_* helper functions are unchanged) ...
 verify_membership() {
@@ -46,6 +73,13 @@ verify_no_membership() {
     "SELECT 1 FROM pg_catalog.pg_roles u JOIN pg_catalog.pg_auth_members m ON m.member = u.oid JOIN pg_catalog.pg_roles g ON m.roleid = g.oid WHERE g.rolname = '$group_role' AND u.rolname = '$user_role'" \
     | grep -q 1; then return 0; else return 1; fi
 }
+verify_user_exists() {
+  local user_role=$1
+  echo "VERIFYING: User role '$user_role' should exist"
+  sudo docker exec postgres psql -U pgadmin -d myapp_db -t -c \
+    "SELECT 1 FROM pg_catalog.pg_roles WHERE rolname = '$user_role'" \
+    | grep -q 1
+}
 verify_user_does_not_exist() {
   local user_role=$1
   echo "VERIFYING: User role '$user_role' should NOT exist"
@@ -60,7 +94,7 @@ verify_user_does_not_exist() {
 cleanup() {
   log_step "Cleaning up..."
   sudo docker compose down -v
-  rm -f *.ldif.tmp
+  rm -f *.tmp
   log_success "Test environment cleaned up."
 }
 trap cleanup EXIT
@@ -88,15 +122,27 @@ log_step "DATABASE STATE AFTER TEST CASE 1"
 sudo docker exec -it postgres psql -U pgadmin -d myapp_db -c "\du"
 log_success "TEST CASE 1 PASSED"
 
-# --- TEST CASE 2: USER DEPROVISIONING ---
-log_step "TEST CASE 2: Deprovisioning (by deleting the readonly_users group)"
+# --- TEST CASE 2: DELETED GROUP IS UNRESOLVED ---
+log_step "TEST CASE 2: A deleted group blocks deprovisioning (by deleting the readonly_users group)"
 sudo docker exec openldap ldapdelete -x -H ldap://localhost:1389 -D "cn=admin,dc=example,dc=org" -w "adminpassword" "cn=readonly_users,ou=groups,dc=example,dc=org"
-run_sync_job
-verify_user_does_not_exist "nc_bcarter"
+run_sync_job_expecting_failure "LDAP groups \[readonly_users\] could not be resolved"
+verify_user_exists "nc_bcarter"
+verify_membership "ldap_readonly_users" "nc_bcarter"
+verify_membership "ldap_db_admins" "nc_jdoe"
 log_step "DATABASE STATE AFTER TEST CASE 2"
 sudo docker exec -it postgres psql -U pgadmin -d myapp_db -c "\du"
 log_success "TEST CASE 2 PASSED"
 
+# --- TEST CASE 2b: USER DEPROVISIONING ---
+log_step "TEST CASE 2b: Deprovisioning (the deleted group counts as empty with missing_group_as_empty)"
+sed 's/missing_group_as_empty: false/missing_group_as_empty: true/' config.yml > config-missing-group-as-empty.yml.tmp
+grep -q "missing_group_as_empty: true" config-missing-group-as-empty.yml.tmp
+run_sync_job config-missing-group-as-empty.yml.tmp
+verify_user_does_not_exist "nc_bcarter"
+log_step "DATABASE STATE AFTER TEST CASE 2b"
+sudo docker exec -it postgres psql -U pgadmin -d myapp_db -c "\du"
+log_success "TEST CASE 2b PASSED"
+
 # --- TEST CASE 3: NEW USER IN MULTIPLE GROUPS ---
 log_step "TEST CASE 3: Add new user 'nc_testuser' to two groups"
 # Create an LDIF for the new user